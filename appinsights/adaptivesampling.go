@@ -0,0 +1,157 @@
+package appinsights
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Weight given to the most recent evaluation interval when updating the
+// moving average of the telemetry rate.
+const adaptiveSamplingMovingAverageRatio = 0.25
+
+// Settings used to initialize a new AdaptiveSampler.
+type AdaptiveSamplingSettings struct {
+	// Target number of telemetry items per second to keep.
+	MaxItemsPerSecond float64
+
+	// Lower bound of the sampling percentage.
+	MinPercentage float64
+
+	// Upper bound of the sampling percentage.
+	MaxPercentage float64
+
+	// Sampling percentage to use until the first evaluation.
+	InitialPercentage float64
+
+	// How often the observed rate of telemetry is measured and the
+	// sampling percentage adjusted.
+	EvaluationInterval time.Duration
+
+	// Telemetry types that are subject to sampling.  Only these count
+	// towards the observed rate.
+	Types TelemetryTypes
+}
+
+// Creates a new AdaptiveSamplingSettings object with default values.
+func NewAdaptiveSamplingSettings() *AdaptiveSamplingSettings {
+	return &AdaptiveSamplingSettings{
+		MaxItemsPerSecond:  5.0,
+		MinPercentage:      0.1,
+		MaxPercentage:      100.0,
+		InitialPercentage:  100.0,
+		EvaluationInterval: time.Duration(15) * time.Second,
+		Types:              DefaultSampledTelemetryTypes,
+	}
+}
+
+// A telemetry processor that adjusts its sampling percentage to keep
+// roughly a target number of telemetry items per second.  Like
+// FixedRateSampler, decisions are made per operation.  The percentage only
+// takes values of the form 100/N, which the data collector expects.  The
+// measured rate and the percentage are reported to diagnostics listeners
+// after every evaluation.
+type AdaptiveSampler struct {
+	settings    AdaptiveSamplingSettings
+	lock        sync.Mutex
+	percentage  float64
+	windowStart time.Time
+	count       int
+	averageRate float64
+	hasAverage  bool
+}
+
+// Creates an adaptive sampler with the specified settings.
+func NewAdaptiveSampler(settings *AdaptiveSamplingSettings) *AdaptiveSampler {
+	sampler := &AdaptiveSampler{
+		settings:    *settings,
+		windowStart: currentClock.Now(),
+	}
+
+	sampler.percentage = sampler.roundPercentage(settings.InitialPercentage)
+	return sampler
+}
+
+// Gets the current sampling percentage.
+func (sampler *AdaptiveSampler) Percentage() float64 {
+	sampler.lock.Lock()
+	defer sampler.lock.Unlock()
+	return sampler.percentage
+}
+
+// Gets the moving average of the telemetry rate, in items per second, as of
+// the most recent evaluation.  Zero until the first evaluation.
+func (sampler *AdaptiveSampler) ItemsPerSecond() float64 {
+	sampler.lock.Lock()
+	defer sampler.lock.Unlock()
+	return sampler.averageRate
+}
+
+// Returns the envelope if it is sampled in, or nil otherwise.
+func (sampler *AdaptiveSampler) Process(envelope *contracts.Envelope) *contracts.Envelope {
+	if telemetryTypeOf(envelope)&sampler.settings.Types == 0 {
+		return envelope
+	}
+
+	sampler.lock.Lock()
+	now := currentClock.Now()
+	if now.Sub(sampler.windowStart) >= sampler.settings.EvaluationInterval {
+		sampler.evaluate(now)
+	}
+
+	sampler.count++
+	percentage := sampler.percentage
+	sampler.lock.Unlock()
+
+	return sampleEnvelope(envelope, percentage, sampler.settings.Types)
+}
+
+// Measures the rate of telemetry over the past window, and adjusts the
+// sampling percentage.  Must be called with the lock held.
+func (sampler *AdaptiveSampler) evaluate(now time.Time) {
+	elapsed := now.Sub(sampler.windowStart).Seconds()
+	rate := float64(sampler.count) / elapsed
+
+	if sampler.hasAverage {
+		sampler.averageRate = adaptiveSamplingMovingAverageRatio*rate + (1.0-adaptiveSamplingMovingAverageRatio)*sampler.averageRate
+	} else {
+		sampler.averageRate = rate
+		sampler.hasAverage = true
+	}
+
+	sampler.windowStart = now
+	sampler.count = 0
+
+	ideal := sampler.settings.MaxPercentage
+	if sampler.averageRate > 0.0 {
+		ideal = 100.0 * sampler.settings.MaxItemsPerSecond / sampler.averageRate
+	}
+
+	percentage := sampler.roundPercentage(ideal)
+	if percentage != sampler.percentage {
+		diagnosticsWriter.Printf("Adaptive sampling percentage changed from %g%% to %g%% (%.2f items/sec)", sampler.percentage, percentage, sampler.averageRate)
+		sampler.percentage = percentage
+	} else {
+		diagnosticsWriter.Printf("Adaptive sampling percentage remains %g%% (%.2f items/sec)", percentage, sampler.averageRate)
+	}
+}
+
+// Clamps the percentage to the configured bounds and rounds it down to the
+// nearest value of the form 100/N.
+func (sampler *AdaptiveSampler) roundPercentage(percentage float64) float64 {
+	percentage = math.Min(percentage, math.Min(sampler.settings.MaxPercentage, 100.0))
+	percentage = math.Max(percentage, sampler.settings.MinPercentage)
+	if percentage <= 0.0 {
+		return 0.0
+	}
+
+	n := math.Ceil(100.0 / percentage)
+	if sampler.settings.MinPercentage > 0.0 && 100.0/n < sampler.settings.MinPercentage {
+		// Rounding took us below the bound; round up instead.
+		n = math.Max(1.0, math.Floor(100.0/sampler.settings.MinPercentage))
+	}
+
+	return 100.0 / n
+}
@@ -0,0 +1,106 @@
+package appinsights
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tracks the specified number of requests per second through the sampler
+// for the specified number of seconds.  Returns the number kept.
+func driveAdaptiveSampler(sampler *AdaptiveSampler, perSecond, seconds int) int {
+	kept := 0
+	for s := 0; s < seconds; s++ {
+		for i := 0; i < perSecond; i++ {
+			item := NewRequestTelemetry("GET", "http://localhost/", time.Millisecond, "200")
+			if sampler.Process(envelopWithOperation(item, newUUID().String())) != nil {
+				kept++
+			}
+		}
+
+		fakeClock.Increment(time.Second)
+	}
+
+	return kept
+}
+
+func TestAdaptiveSampler(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	var messages []string
+	NewDiagnosticsMessageListener(func(msg string) error {
+		if strings.Contains(msg, "Adaptive sampling") {
+			messages = append(messages, msg)
+		}
+
+		return nil
+	})
+	defer resetDiagnosticsListeners()
+
+	sampler := NewAdaptiveSampler(NewAdaptiveSamplingSettings())
+	if p := sampler.Percentage(); p != 100.0 {
+		t.Errorf("Initial percentage is %g, want 100", p)
+	}
+
+	// 100 items/sec against a target of 5/sec
+	driveAdaptiveSampler(sampler, 100, 15)
+	driveAdaptiveSampler(sampler, 100, 1)
+	if p := sampler.Percentage(); p != 5.0 {
+		t.Errorf("Percentage is %g, want 5", p)
+	}
+
+	if len(messages) != 1 || !strings.Contains(messages[0], "from 100% to 5% (100.00 items/sec)") {
+		t.Errorf("Unexpected diagnostics: %q", messages)
+	}
+
+	if rate := sampler.ItemsPerSecond(); rate != 100.0 {
+		t.Errorf("Rate is %g, want 100", rate)
+	}
+
+	kept := driveAdaptiveSampler(sampler, 100, 14)
+	if kept < 35 || kept > 105 {
+		t.Errorf("Kept %d items in 14 seconds, want about 70", kept)
+	}
+
+	// The rate is reported even when the percentage holds steady.
+	driveAdaptiveSampler(sampler, 1, 1)
+	if len(messages) != 2 || !strings.Contains(messages[1], "remains 5% (100.00 items/sec)") {
+		t.Errorf("Unexpected diagnostics: %q", messages)
+	}
+
+	// Traffic drops off; the moving average brings the percentage up
+	// gradually, in steps of 100/N.
+	driveAdaptiveSampler(sampler, 1, 14)
+	driveAdaptiveSampler(sampler, 1, 1)
+	if p := sampler.Percentage(); p != 100.0/16.0 {
+		t.Errorf("Percentage is %g, want 6.25", p)
+	}
+}
+
+func TestAdaptiveSamplerBounds(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	settings := NewAdaptiveSamplingSettings()
+	settings.MinPercentage = 30.0
+	settings.MaxPercentage = 60.0
+	sampler := NewAdaptiveSampler(settings)
+
+	// 100/2 is the largest 100/N step within bounds.
+	if p := sampler.Percentage(); p != 50.0 {
+		t.Errorf("Initial percentage is %g, want 50", p)
+	}
+
+	driveAdaptiveSampler(sampler, 1000, 16)
+	if p := sampler.Percentage(); p != 100.0/3.0 {
+		t.Errorf("Percentage is %g, want 33.3", p)
+	}
+
+	// Metrics are not sampled and do not count towards the rate.
+	for i := 0; i < 100; i++ {
+		if sampler.Process(envelopWithOperation(NewMetricTelemetry("~metric~", 1.0), newUUID().String())) == nil {
+			t.Fatal("Metric was sampled")
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package appinsights
 
 import (
+	"context"
 	"time"
 
 	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
@@ -30,6 +31,13 @@ type TelemetryClient interface {
 	// Submits the specified telemetry item.
 	Track(telemetry Telemetry)
 
+	// Submits the specified telemetry item with values carried by ctx:
+	// the operation from OperationFromContext and the tags and properties
+	// from ContextWithTags and ContextWithProperties are added to it,
+	// and ctx is passed to telemetry initializers.  A nil ctx is treated
+	// as context.Background().
+	TrackWithContext(ctx context.Context, telemetry Telemetry)
+
 	// Log a user action with the specified name
 	TrackEvent(name string)
 
@@ -56,12 +64,39 @@ type TelemetryClient interface {
 	// error or Stringer. The current callstack is collected
 	// automatically.
 	TrackException(err interface{})
+
+	// Log a user action with the specified name, with values carried by
+	// ctx.  See TrackWithContext.
+	TrackEventWithContext(ctx context.Context, name string)
+
+	// Log a trace message with the specified severity level, with values
+	// carried by ctx.  See TrackWithContext.
+	TrackTraceWithContext(ctx context.Context, name string, severity contracts.SeverityLevel)
+
+	// Log an exception with the specified error, with values carried by
+	// ctx.  See TrackException and TrackWithContext.
+	TrackExceptionWithContext(ctx context.Context, err interface{})
+
+	// Starts an operation that tracks an incoming request, or another
+	// unit of work, with the specified name.  It is a child of the
+	// operation in ctx, if any.  Returns a copy of ctx that carries the
+	// new operation.  The operation's telemetry is submitted when End is
+	// called on it.
+	StartRequestOperation(ctx context.Context, name string) (context.Context, *Operation)
+
+	// Starts an operation that tracks a call to a dependency with the
+	// specified name, type and target.  It is a child of the operation in
+	// ctx, if any.  Returns a copy of ctx that carries the new operation.
+	// The operation's telemetry is submitted when End is called on it.
+	StartDependencyOperation(ctx context.Context, name, dependencyType, target string) (context.Context, *Operation)
 }
 
 type telemetryClient struct {
-	channel   TelemetryChannel
-	context   *TelemetryContext
-	isEnabled bool
+	channel     TelemetryChannel
+	context     *TelemetryContext
+	processors  []TelemetryProcessor
+	isEnabled   bool
+	liveMetrics *quickPulse
 }
 
 // Creates a new telemetry client instance that submits telemetry with the
@@ -73,11 +108,36 @@ func NewTelemetryClient(iKey string) TelemetryClient {
 // Creates a new telemetry client instance configured by the specified
 // TelemetryConfiguration object.
 func NewTelemetryClientFromConfig(config *TelemetryConfiguration) TelemetryClient {
-	return &telemetryClient{
-		channel:   NewInMemoryChannel(config),
-		context:   config.setupContext(),
-		isEnabled: true,
+	client := &telemetryClient{
+		channel:    newChannelFromConfig(config),
+		context:    config.setupContext(),
+		processors: append([]TelemetryProcessor(nil), config.TelemetryProcessors...),
+		isEnabled:  !config.Disabled,
+	}
+
+	if config.LiveMetrics && !config.Disabled {
+		client.liveMetrics = newQuickPulse(config, client.context)
+		if channel, ok := client.channel.(closeHookChannel); ok {
+			channel.onClose(client.liveMetrics.stop)
+		}
+	}
+
+	return client
+}
+
+// Creates a PersistentChannel if the configuration specifies a storage
+// directory, or an InMemoryChannel otherwise.
+func newChannelFromConfig(config *TelemetryConfiguration) TelemetryChannel {
+	if config.StorageDirectory != "" {
+		channel, err := NewPersistentChannel(config)
+		if err == nil {
+			return channel
+		}
+
+		diagnosticsWriter.Printf("Failed to set up telemetry storage, falling back to memory: %s", err.Error())
 	}
+
+	return NewInMemoryChannel(config)
 }
 
 // Gets the telemetry context for this client.  Values found on this context
@@ -109,8 +169,28 @@ func (tc *telemetryClient) SetIsEnabled(isEnabled bool) {
 
 // Submits the specified telemetry item.
 func (tc *telemetryClient) Track(item Telemetry) {
+	tc.TrackWithContext(backgroundContext, item)
+}
+
+// Submits the specified telemetry item with values carried by ctx: the
+// operation from OperationFromContext and the tags and properties from
+// ContextWithTags and ContextWithProperties are added to it, and ctx is
+// passed to telemetry initializers.  A nil ctx is treated as
+// context.Background().
+func (tc *telemetryClient) TrackWithContext(ctx context.Context, item Telemetry) {
+	if ctx == nil {
+		ctx = backgroundContext
+	}
+
 	if tc.isEnabled && item != nil {
-		tc.channel.Send(tc.context.envelop(item))
+		applyContextValues(ctx, item)
+		if tc.liveMetrics != nil {
+			tc.liveMetrics.observe(item)
+		}
+
+		if envelope := processTelemetry(tc.processors, tc.context.envelopWithContext(ctx, item)); envelope != nil {
+			tc.channel.Send(envelope)
+		}
 	}
 }
 
@@ -153,3 +233,56 @@ func (tc *telemetryClient) TrackAvailability(name string, duration time.Duration
 func (tc *telemetryClient) TrackException(err interface{}) {
 	tc.Track(newExceptionTelemetry(err, 1))
 }
+
+// Log a user action with the specified name, with values carried by ctx.
+// See TrackWithContext.
+func (tc *telemetryClient) TrackEventWithContext(ctx context.Context, name string) {
+	tc.TrackWithContext(ctx, NewEventTelemetry(name))
+}
+
+// Log a trace message with the specified severity level, with values
+// carried by ctx.  See TrackWithContext.
+func (tc *telemetryClient) TrackTraceWithContext(ctx context.Context, message string, severity contracts.SeverityLevel) {
+	tc.TrackWithContext(ctx, NewTraceTelemetry(message, severity))
+}
+
+// Log an exception with the specified error, with values carried by ctx.
+// See TrackException and TrackWithContext.
+func (tc *telemetryClient) TrackExceptionWithContext(ctx context.Context, err interface{}) {
+	tc.TrackWithContext(ctx, newExceptionTelemetry(err, 1))
+}
+
+// Starts an operation that tracks an incoming request, or another unit of
+// work, with the specified name.  It is a child of the operation in ctx, if
+// any.  Returns a copy of ctx that carries the new operation.  The
+// operation's telemetry is submitted when End is called on it.
+func (tc *telemetryClient) StartRequestOperation(ctx context.Context, name string) (context.Context, *Operation) {
+	telem := NewRequestTelemetry("", "", 0, "200")
+	telem.Name = name
+
+	operation := newOperation(tc, OperationFromContext(ctx), telem, name)
+	operation.id = newRequestTelemetryId(operation, tc.context.Propagation)
+	telem.Id = operation.id
+
+	return contextWithOperation(ctx, operation), operation
+}
+
+// Starts an operation that tracks a call to a dependency with the specified
+// name, type and target.  It is a child of the operation in ctx, if any.
+// Returns a copy of ctx that carries the new operation.  The operation's
+// telemetry is submitted when End is called on it.
+func (tc *telemetryClient) StartDependencyOperation(ctx context.Context, name, dependencyType, target string) (context.Context, *Operation) {
+	telem := NewRemoteDependencyTelemetry(name, dependencyType, target, true)
+
+	parent := OperationFromContext(ctx)
+	operation := newOperation(tc, parent, telem, "")
+	if parent != nil {
+		operation.id = newDependencyTelemetryId(parent, tc.context.Propagation)
+	} else {
+		operation.id = newDependencyTelemetryId(operation, tc.context.Propagation)
+	}
+
+	telem.Id = operation.id
+
+	return contextWithOperation(ctx, operation), operation
+}
@@ -15,6 +15,9 @@ type TelemetryConfiguration struct {
 	// Endpoint URL where data will be submitted.
 	EndpointUrl string
 
+	// Base URL of the Live Metrics endpoint.
+	LiveEndpointUrl string
+
 	// Maximum number of telemetry items that can be submitted in each
 	// request.  If this many items are buffered, the buffer will be
 	// flushed before MaxBatchInterval expires.
@@ -25,21 +28,134 @@ type TelemetryConfiguration struct {
 
 	// Customized http client if desired (will use http.DefaultClient otherwise)
 	Client *http.Client
+
+	// Number of telemetry items that can be queued for the channel's
+	// submission goroutine before QueuePolicy takes effect.  Zero means
+	// that each item is handed directly to the submission goroutine,
+	// except with QueueDropNewest and QueueDropOldest, which would then
+	// drop nearly everything; those use a capacity of 1024 instead.
+	QueueCapacity int
+
+	// Determines what happens when telemetry is sent while the queue is
+	// full.  Defaults to QueueBlock.
+	QueuePolicy QueuePolicy
+
+	// Maximum time to wait for room in the queue when QueuePolicy is
+	// QueueBlockWithTimeout.  Zero means one second.
+	QueueTimeout time.Duration
+
+	// Directory in which telemetry is stored until it has been accepted
+	// by the data collector.  If set, the client will be created with a
+	// PersistentChannel instead of an InMemoryChannel.  If the directory
+	// cannot be created or written, NewTelemetryClientFromConfig falls
+	// back to an InMemoryChannel, and telemetry is not kept on disk; the
+	// failure is only reported to diagnostics listeners.  To handle the
+	// error instead, call NewPersistentChannel directly.
+	StorageDirectory string
+
+	// Maximum number of bytes of telemetry to keep in StorageDirectory.
+	// When exceeded, the oldest stored telemetry is discarded.  Zero
+	// indicates no limit.
+	MaxStorageSize int64
+
+	// Maximum age of telemetry kept in StorageDirectory.  Older
+	// telemetry is discarded rather than replayed.  Zero indicates no
+	// limit.
+	MaxStorageAge time.Duration
+
+	// Name of the service submitting telemetry, written to the
+	// ai.cloud.role tag if set.
+	CloudRoleName string
+
+	// Name of this instance of the service, written to the
+	// ai.cloud.roleInstance tag.  Defaults to the host name if empty.
+	CloudRoleInstance string
+
+	// If set, clients created from this configuration start out
+	// disabled and silently swallow telemetry.
+	Disabled bool
+
+	// Processors that every telemetry item passes through, in order,
+	// before it is sent to the channel.
+	TelemetryProcessors []TelemetryProcessor
+
+	// Initializers that are run on every telemetry item, in order,
+	// before it is wrapped in an envelope.
+	TelemetryInitializers []TelemetryInitializer
+
+	// Determines which correlation headers HttpHandler and HttpTransport
+	// read and write.
+	Propagation PropagationMode
+
+	// Application Insights application ID of this service.  If set, it is
+	// exchanged with other services in Request-Context headers so that
+	// calls between components are labelled in the Application Map.
+	ApplicationId string
+
+	// If set, clients created from this configuration stream request,
+	// dependency and exception rates, CPU and memory usage to the Live
+	// Metrics view in the portal, from LiveEndpointUrl, whenever
+	// somebody is watching it.  Ignored if Disabled is set.
+	LiveMetrics bool
 }
 
+// Determines how a telemetry channel behaves when items are sent faster than
+// they can be accepted.
+type QueuePolicy int
+
+const (
+	// Wait until there is room in the queue.
+	QueueBlock QueuePolicy = iota
+
+	// Discard the item being sent.
+	QueueDropNewest
+
+	// Discard the oldest queued item to make room for the one being sent.
+	QueueDropOldest
+
+	// Wait until there is room in the queue or QueueTimeout expires,
+	// then discard the item being sent.
+	QueueBlockWithTimeout
+)
+
+// Determines which headers are used to correlate HTTP requests across
+// services.
+type PropagationMode int
+
+const (
+	// Read and write W3C Trace Context headers, as well as the Request-Id
+	// and Correlation-Context headers used by older Application Insights
+	// SDKs.  traceparent is preferred when both are received.
+	PropagateW3CAndLegacy PropagationMode = iota
+
+	// Read and write only W3C Trace Context headers.
+	PropagateW3C
+
+	// Read and write only Request-Id and Correlation-Context headers, and
+	// use hierarchical Request-Id values as telemetry IDs, as older
+	// Application Insights SDKs do.
+	PropagateLegacy
+)
+
 // Creates a new TelemetryConfiguration object with the specified
 // instrumentation key and default values.
 func NewTelemetryConfiguration(instrumentationKey string) *TelemetryConfiguration {
 	return &TelemetryConfiguration{
 		InstrumentationKey: instrumentationKey,
-		EndpointUrl:        "https://dc.services.visualstudio.com/v2/track",
+		EndpointUrl:        defaultIngestionEndpoint + trackPath,
+		LiveEndpointUrl:    defaultLiveEndpoint,
 		MaxBatchSize:       1024,
 		MaxBatchInterval:   time.Duration(10) * time.Second,
+		MaxStorageSize:     50 * 1024 * 1024,
+		MaxStorageAge:      time.Duration(48) * time.Hour,
 	}
 }
 
 func (config *TelemetryConfiguration) setupContext() *TelemetryContext {
 	context := NewTelemetryContext(config.InstrumentationKey)
+	context.Initializers = append(context.Initializers, config.TelemetryInitializers...)
+	context.Propagation = config.Propagation
+	context.ApplicationId = config.ApplicationId
 	context.Tags.Internal().SetSdkVersion(sdkName + ":" + Version)
 	context.Tags.Device().SetOsVersion(runtime.GOOS)
 
@@ -48,5 +164,13 @@ func (config *TelemetryConfiguration) setupContext() *TelemetryContext {
 		context.Tags.Cloud().SetRoleInstance(hostname)
 	}
 
+	if config.CloudRoleName != "" {
+		context.Tags.Cloud().SetRole(config.CloudRoleName)
+	}
+
+	if config.CloudRoleInstance != "" {
+		context.Tags.Cloud().SetRoleInstance(config.CloudRoleInstance)
+	}
+
 	return context
 }
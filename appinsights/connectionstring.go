@@ -0,0 +1,138 @@
+package appinsights
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	defaultIngestionEndpoint = "https://dc.services.visualstudio.com"
+	defaultLiveEndpoint      = "https://rt.services.visualstudio.com"
+	trackPath                = "/v2/track"
+)
+
+// Connection string keys, compared case-insensitively.
+const (
+	connectionStringInstrumentationKey = "instrumentationkey"
+	connectionStringIngestionEndpoint  = "ingestionendpoint"
+	connectionStringLiveEndpoint       = "liveendpoint"
+	connectionStringEndpointSuffix     = "endpointsuffix"
+	connectionStringLocation           = "location"
+	connectionStringAuthorization      = "authorization"
+)
+
+// Creates a new TelemetryConfiguration object from an Application Insights
+// connection string, such as
+// "InstrumentationKey=...;IngestionEndpoint=https://...".  Endpoints that
+// are not given explicitly are derived from EndpointSuffix and Location, if
+// present, or use the global defaults otherwise.
+func NewTelemetryConfigurationFromConnectionString(connectionString string) (*TelemetryConfiguration, error) {
+	values, err := parseConnectionString(connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	ikey := values[connectionStringInstrumentationKey]
+	if ikey == "" {
+		return nil, fmt.Errorf("connection string does not contain an InstrumentationKey")
+	}
+
+	if auth, ok := values[connectionStringAuthorization]; ok && !strings.EqualFold(auth, "ikey") {
+		return nil, fmt.Errorf("connection string has unsupported Authorization %q", auth)
+	}
+
+	ingestionEndpoint, err := connectionStringEndpoint(values, connectionStringIngestionEndpoint, "dc", defaultIngestionEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	liveEndpoint, err := connectionStringEndpoint(values, connectionStringLiveEndpoint, "live", defaultLiveEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	config := NewTelemetryConfiguration(ikey)
+	config.EndpointUrl = ingestionEndpoint + trackPath
+	config.LiveEndpointUrl = liveEndpoint
+	return config, nil
+}
+
+// Splits a connection string into its key/value pairs.  Keys are lowercased.
+func parseConnectionString(connectionString string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	for _, segment := range strings.Split(connectionString, ";") {
+		segment = strings.TrimSpace(segment)
+		if segment == "" {
+			// Tolerate trailing and doubled semicolons.
+			continue
+		}
+
+		eq := strings.IndexByte(segment, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("connection string segment %q is not of the form key=value", segment)
+		}
+
+		key := strings.ToLower(strings.TrimSpace(segment[:eq]))
+		value := strings.TrimSpace(segment[eq+1:])
+		if key == "" {
+			return nil, fmt.Errorf("connection string segment %q has an empty key", segment)
+		}
+
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("connection string contains %q more than once", segment[:eq])
+		}
+
+		values[key] = value
+	}
+
+	if len(values) == 0 {
+		return nil, fmt.Errorf("connection string is empty")
+	}
+
+	return values, nil
+}
+
+// Determines an endpoint from a connection string: the explicit value under
+// key if present, otherwise one built from EndpointSuffix (with an optional
+// Location prefix) and the specified service prefix, otherwise the
+// default.  The result has no trailing slash.
+func connectionStringEndpoint(values map[string]string, key, prefix, defaultEndpoint string) (string, error) {
+	if endpoint, ok := values[key]; ok {
+		if err := validateEndpoint(endpoint); err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(endpoint, "/"), nil
+	}
+
+	if suffix := strings.Trim(values[connectionStringEndpointSuffix], "./"); suffix != "" {
+		host := prefix + "." + suffix
+		if location := strings.Trim(values[connectionStringLocation], "."); location != "" {
+			host = location + "." + host
+		}
+
+		endpoint := "https://" + host
+		if err := validateEndpoint(endpoint); err != nil {
+			return "", err
+		}
+
+		return endpoint, nil
+	}
+
+	return defaultEndpoint, nil
+}
+
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("connection string has invalid endpoint %q: %s", endpoint, err.Error())
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("connection string has invalid endpoint %q: must be an absolute http or https URL", endpoint)
+	}
+
+	return nil
+}
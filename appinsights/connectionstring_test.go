@@ -0,0 +1,101 @@
+package appinsights
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConnectionString(t *testing.T) {
+	tests := []struct {
+		connectionString string
+		ikey             string
+		endpoint         string
+		liveEndpoint     string
+	}{
+		{
+			"InstrumentationKey=" + test_ikey,
+			test_ikey,
+			"https://dc.services.visualstudio.com/v2/track",
+			"https://rt.services.visualstudio.com",
+		},
+		{
+			"InstrumentationKey=" + test_ikey + ";IngestionEndpoint=https://westus2-1.in.applicationinsights.azure.com/;LiveEndpoint=https://westus2.livediagnostics.monitor.azure.com/",
+			test_ikey,
+			"https://westus2-1.in.applicationinsights.azure.com/v2/track",
+			"https://westus2.livediagnostics.monitor.azure.com",
+		},
+		{
+			" instrumentationkey = " + test_ikey + " ; EndpointSuffix=applicationinsights.us;",
+			test_ikey,
+			"https://dc.applicationinsights.us/v2/track",
+			"https://live.applicationinsights.us",
+		},
+		{
+			"InstrumentationKey=" + test_ikey + ";EndpointSuffix=applicationinsights.azure.cn;Location=chinaeast2",
+			test_ikey,
+			"https://chinaeast2.dc.applicationinsights.azure.cn/v2/track",
+			"https://chinaeast2.live.applicationinsights.azure.cn",
+		},
+		{
+			"Authorization=ikey;InstrumentationKey=" + test_ikey + ";EndpointSuffix=example.com;IngestionEndpoint=http://localhost:8080",
+			test_ikey,
+			"http://localhost:8080/v2/track",
+			"https://live.example.com",
+		},
+	}
+
+	for _, test := range tests {
+		config, err := NewTelemetryConfigurationFromConnectionString(test.connectionString)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", test.connectionString, err.Error())
+			continue
+		}
+
+		if config.InstrumentationKey != test.ikey {
+			t.Errorf("InstrumentationKey is %s, want %s", config.InstrumentationKey, test.ikey)
+		}
+
+		if config.EndpointUrl != test.endpoint {
+			t.Errorf("EndpointUrl is %s, want %s", config.EndpointUrl, test.endpoint)
+		}
+
+		if config.LiveEndpointUrl != test.liveEndpoint {
+			t.Errorf("LiveEndpointUrl is %s, want %s", config.LiveEndpointUrl, test.liveEndpoint)
+		}
+
+		if config.MaxBatchSize != 1024 {
+			t.Errorf("MaxBatchSize is %d, want default", config.MaxBatchSize)
+		}
+	}
+}
+
+func TestInvalidConnectionString(t *testing.T) {
+	tests := []struct {
+		connectionString string
+		message          string
+	}{
+		{"", "empty"},
+		{";;", "empty"},
+		{"InstrumentationKey", "key=value"},
+		{"=foo;InstrumentationKey=" + test_ikey, "empty key"},
+		{"IngestionEndpoint=https://localhost", "InstrumentationKey"},
+		{"InstrumentationKey=", "InstrumentationKey"},
+		{"InstrumentationKey=a;instrumentationKey=b", "more than once"},
+		{"InstrumentationKey=" + test_ikey + ";IngestionEndpoint=localhost:8080", "invalid endpoint"},
+		{"InstrumentationKey=" + test_ikey + ";LiveEndpoint=ftp://localhost", "invalid endpoint"},
+		{"InstrumentationKey=" + test_ikey + ";Authorization=aad", "Authorization"},
+	}
+
+	for _, test := range tests {
+		config, err := NewTelemetryConfigurationFromConnectionString(test.connectionString)
+		if err == nil {
+			t.Errorf("Expected error parsing %q", test.connectionString)
+		} else if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Error for %q is %q, want it to mention %q", test.connectionString, err.Error(), test.message)
+		}
+
+		if config != nil {
+			t.Errorf("Expected nil configuration for %q", test.connectionString)
+		}
+	}
+}
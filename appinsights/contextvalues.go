@@ -0,0 +1,94 @@
+package appinsights
+
+import (
+	"context"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+type contextPropertiesKey struct{}
+
+type contextTagsKey struct{}
+
+// Returns a copy of ctx carrying the specified custom properties, in
+// addition to any that ctx already carries.  They are added to telemetry
+// tracked with the context unless the item already has a property of the
+// same name.  A nil ctx is treated as context.Background().
+func ContextWithProperties(ctx context.Context, properties map[string]string) context.Context {
+	if ctx == nil {
+		ctx = backgroundContext
+	}
+
+	merged := make(map[string]string)
+	for k, v := range propertiesFromContext(ctx) {
+		merged[k] = v
+	}
+
+	for k, v := range properties {
+		merged[k] = v
+	}
+
+	return context.WithValue(ctx, contextPropertiesKey{}, merged)
+}
+
+// Returns a copy of ctx carrying the specified context tags, such as a user
+// or session ID, in addition to any that ctx already carries.  They are
+// added to telemetry tracked with the context unless the item already has
+// the tag.  A nil ctx is treated as context.Background().
+func ContextWithTags(ctx context.Context, tags contracts.ContextTags) context.Context {
+	if ctx == nil {
+		ctx = backgroundContext
+	}
+
+	merged := make(contracts.ContextTags)
+	for k, v := range tagsFromContext(ctx) {
+		merged[k] = v
+	}
+
+	for k, v := range tags {
+		merged[k] = v
+	}
+
+	return context.WithValue(ctx, contextTagsKey{}, merged)
+}
+
+func propertiesFromContext(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+
+	properties, _ := ctx.Value(contextPropertiesKey{}).(map[string]string)
+	return properties
+}
+
+func tagsFromContext(ctx context.Context) contracts.ContextTags {
+	if ctx == nil {
+		return nil
+	}
+
+	tags, _ := ctx.Value(contextTagsKey{}).(contracts.ContextTags)
+	return tags
+}
+
+// Adds the operation tags, context tags and custom properties carried by
+// ctx to a telemetry item, without overwriting values already set on it.
+func applyContextValues(ctx context.Context, item Telemetry) {
+	tags := item.ContextTags()
+	if operation := OperationFromContext(ctx); operation != nil {
+		operation.correlate(tags, operation.id)
+	}
+
+	if tags != nil {
+		for k, v := range tagsFromContext(ctx) {
+			setDefaultTag(tags, k, v)
+		}
+	}
+
+	if properties := item.GetProperties(); properties != nil {
+		for k, v := range propertiesFromContext(ctx) {
+			if _, ok := properties[k]; !ok {
+				properties[k] = v
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package appinsights
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func TestTrackWithContext(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	var initializedWith context.Context
+	config := NewTelemetryConfiguration(test_ikey)
+	config.TelemetryInitializers = []TelemetryInitializer{
+		TelemetryInitializerFunc(func(ctx context.Context, item Telemetry) {
+			initializedWith = ctx
+		}),
+	}
+
+	client, transmitter := newTestChannelServer(config)
+	defer transmitter.Close()
+
+	ctx, operation := client.StartRequestOperation(context.Background(), "op")
+
+	tags := make(contracts.ContextTags)
+	tags.User().SetId("alice")
+	tags.Session().SetId("session")
+	ctx = ContextWithTags(ctx, tags)
+	ctx = ContextWithProperties(ctx, map[string]string{"tenant": "contoso", "region": "west"})
+	ctx = ContextWithProperties(ctx, map[string]string{"region": "east"})
+
+	trace := NewTraceTelemetry("~msg~", Information)
+	trace.Properties["tenant"] = "fabrikam"
+	trace.Tags.Session().SetId("explicit")
+	client.TrackWithContext(ctx, trace)
+	if initializedWith != ctx {
+		t.Error("Initializers did not receive the context")
+	}
+
+	client.TrackExceptionWithContext(ctx, "~error~")
+	client.TrackEventWithContext(context.Background(), "~event~")
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 3 {
+		t.Fatalf("Tracked %d items, want 3", len(items))
+	}
+
+	traceEnv, exceptionEnv, eventEnv := items[0], items[1], items[2]
+	traceData := traceEnv.Data.(*contracts.Data).BaseData.(*contracts.MessageData)
+
+	checkDataContract(t, "ai.operation.id", traceEnv.Tags[contracts.OperationId], operation.OperationId())
+	checkDataContract(t, "ai.operation.parentId", traceEnv.Tags[contracts.OperationParentId], operation.Id())
+	checkDataContract(t, "ai.user.id", traceEnv.Tags[contracts.UserId], "alice")
+	checkDataContract(t, "ai.session.id", traceEnv.Tags[contracts.SessionId], "explicit")
+	checkDataContract(t, "Properties[tenant]", traceData.Properties["tenant"], "fabrikam")
+	checkDataContract(t, "Properties[region]", traceData.Properties["region"], "east")
+
+	exceptionData := exceptionEnv.Data.(*contracts.Data).BaseData.(*contracts.ExceptionData)
+	checkDataContract(t, "exception ai.operation.parentId", exceptionEnv.Tags[contracts.OperationParentId], operation.Id())
+	checkDataContract(t, "exception Properties[tenant]", exceptionData.Properties["tenant"], "contoso")
+	if frames := exceptionData.Exceptions[0].ParsedStack; len(frames) == 0 || !strings.HasSuffix(frames[0].Method, "TestTrackWithContext") {
+		t.Error("Unexpected top stack frame")
+	}
+
+	if _, ok := eventEnv.Tags[contracts.UserId]; ok {
+		t.Error("Event tracked without context has a user ID")
+	}
+}
+
+func TestTrackWithNilContext(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	var initializedWith context.Context
+	config := NewTelemetryConfiguration(test_ikey)
+	config.TelemetryInitializers = []TelemetryInitializer{
+		TelemetryInitializerFunc(func(ctx context.Context, item Telemetry) {
+			initializedWith = ctx
+		}),
+	}
+
+	client, transmitter := newTestChannelServer(config)
+	defer transmitter.Close()
+
+	var ctx context.Context
+	client.TrackWithContext(ctx, NewTraceTelemetry("~msg~", Information))
+	if initializedWith == nil {
+		t.Error("Initializers received a nil context")
+	}
+
+	ctx = ContextWithProperties(nil, map[string]string{"tenant": "contoso"})
+	client.TrackEventWithContext(ctx, "~event~")
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 2 {
+		t.Fatalf("Tracked %d items, want 2", len(items))
+	}
+
+	eventData := items[1].Data.(*contracts.Data).BaseData.(*contracts.EventData)
+	checkDataContract(t, "Properties[tenant]", eventData.Properties["tenant"], "contoso")
+}
@@ -0,0 +1,311 @@
+package appinsights
+
+import (
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Headers used to correlate HTTP requests across services.  traceparent and
+// tracestate are defined by the W3C Trace Context recommendation.
+// Request-Id and Correlation-Context are understood by older Application
+// Insights SDKs; Request-Id values have the form "|<operation ID>.<...>.".
+// Request-Context carries the application ID of the sender.
+const (
+	traceparentHeader        = "traceparent"
+	tracestateHeader         = "tracestate"
+	requestIdHeader          = "Request-Id"
+	correlationContextHeader = "Correlation-Context"
+	requestContextHeader     = "Request-Context"
+)
+
+// The traceparent version written by this SDK.
+const traceparentVersion = "00"
+
+// Set in the traceparent flags when the caller may have recorded its trace.
+const traceFlagSampled byte = 0x01
+
+// Longest Request-Id that will be generated.  Longer hierarchical IDs are
+// truncated and marked with '#'.
+const requestIdMaxLength = 1024
+
+// Request-Context key holding the sender's application ID, and the prefix
+// given to application IDs in it.
+const (
+	requestContextAppIdKey = "appId"
+	appIdPrefix            = "cid-v1:"
+)
+
+// Dependency type of HTTP calls to services that returned their
+// application ID.
+const trackedComponentDependencyType = "Http (tracked component)"
+
+// Generates a new random W3C trace-id, used as an operation ID.
+func newTraceId() string {
+	u := newUUID()
+	return hex.EncodeToString(u[:])
+}
+
+// Generates a new random W3C span-id, used as the Id of request and
+// dependency telemetry.
+func newSpanId() string {
+	u := newUUID()
+	return hex.EncodeToString(u[:8])
+}
+
+// Parses a traceparent header value of the form
+// "<version>-<trace-id>-<parent-id>-<flags>".  Returns false if the value is
+// not well-formed.  Values from future versions are accepted if they start
+// with the fields of the current version.
+func parseTraceparent(traceparent string) (traceId, parentId string, flags byte, ok bool) {
+	traceparent = strings.TrimSpace(traceparent)
+	if len(traceparent) < 55 || traceparent[2] != '-' || traceparent[35] != '-' || traceparent[52] != '-' {
+		return "", "", 0, false
+	}
+
+	version := traceparent[:2]
+	if !isLowerHex(version) || version == "ff" {
+		return "", "", 0, false
+	}
+
+	if len(traceparent) > 55 && (version == traceparentVersion || traceparent[55] != '-') {
+		return "", "", 0, false
+	}
+
+	traceId = traceparent[3:35]
+	parentId = traceparent[36:52]
+	if !isLowerHex(traceId) || isZeroId(traceId) || !isLowerHex(parentId) || isZeroId(parentId) {
+		return "", "", 0, false
+	}
+
+	if !isLowerHex(traceparent[53:55]) {
+		return "", "", 0, false
+	}
+
+	flagBytes, _ := hex.DecodeString(traceparent[53:55])
+	return traceId, parentId, flagBytes[0], true
+}
+
+// Formats a traceparent header value for a call made as the specified span.
+func formatTraceparent(traceId, spanId string, flags byte) string {
+	return traceparentVersion + "-" + traceId + "-" + spanId + "-" + hex.EncodeToString([]byte{flags})
+}
+
+// Formats a Request-Id header value for a call made within the specified
+// operation.
+func formatRequestId(operationId, spanId string) string {
+	return "|" + operationId + "." + spanId + "."
+}
+
+// Extracts the operation ID from a Request-Id header value.  Returns false
+// if the value is not well-formed.
+func parseRequestId(requestId string) (string, bool) {
+	if !strings.HasPrefix(requestId, "|") {
+		return "", false
+	}
+
+	root := requestId[1:]
+	if dot := strings.IndexByte(root, '.'); dot >= 0 {
+		root = root[:dot]
+	}
+
+	if root == "" {
+		return "", false
+	}
+
+	return root, true
+}
+
+// Appends a suffix to a hierarchical Request-Id.  If the result would be
+// too long, the parent is truncated at a delimiter and the suffix replaced
+// with a random one ending in '#'.
+func appendRequestId(parentId, suffix string) string {
+	if len(parentId)+len(suffix) <= requestIdMaxLength {
+		return parentId + suffix
+	}
+
+	suffix = newSpanId()[:8] + "#"
+	trimmed := parentId[:requestIdMaxLength-len(suffix)]
+	if i := strings.LastIndexAny(trimmed, "._"); i >= 0 {
+		trimmed = trimmed[:i+1]
+	}
+
+	return trimmed + suffix
+}
+
+// Parses a Correlation-Context header value, a comma-separated list of
+// key=value pairs.  Malformed pairs are skipped.
+func parseCorrelationContext(values []string) map[string]string {
+	var result map[string]string
+	for _, value := range values {
+		for _, pair := range strings.Split(value, ",") {
+			eq := strings.IndexByte(pair, '=')
+			if eq < 0 {
+				continue
+			}
+
+			key := strings.TrimSpace(pair[:eq])
+			if key == "" {
+				continue
+			}
+
+			if result == nil {
+				result = make(map[string]string)
+			}
+
+			result[key] = strings.TrimSpace(pair[eq+1:])
+		}
+	}
+
+	return result
+}
+
+// Formats a Correlation-Context header value, with keys in sorted order.
+func formatCorrelationContext(baggage map[string]string) string {
+	keys := make([]string, 0, len(baggage))
+	for k := range baggage {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + baggage[k]
+	}
+
+	return strings.Join(pairs, ", ")
+}
+
+// Gets the application ID, including its "cid-v1:" prefix, from a
+// Request-Context header.  Returns an empty string if there is none.
+func requestContextAppId(header http.Header) string {
+	for _, value := range header[http.CanonicalHeaderKey(requestContextHeader)] {
+		for _, pair := range strings.Split(value, ",") {
+			eq := strings.IndexByte(pair, '=')
+			if eq >= 0 && strings.TrimSpace(pair[:eq]) == requestContextAppIdKey {
+				return strings.TrimSpace(pair[eq+1:])
+			}
+		}
+	}
+
+	return ""
+}
+
+// Writes this service's application ID to a Request-Context header, if it
+// is known.
+func setRequestContextAppId(header http.Header, context *TelemetryContext) {
+	if context.ApplicationId != "" {
+		header.Set(requestContextHeader, requestContextAppIdKey+"="+appIdPrefix+context.ApplicationId)
+	}
+}
+
+// Returns true if an application ID received in a Request-Context header
+// belongs to another component than this one.
+func isOtherAppId(appId string, context *TelemetryContext) bool {
+	return appId != "" && appId != appIdPrefix+context.ApplicationId
+}
+
+// Reads correlation headers from an incoming request into the operation
+// that will track it.  If no headers that the mode allows are present, the
+// operation is left unchanged.
+func extractCorrelationHeaders(header http.Header, operation *Operation, mode PropagationMode) {
+	found := false
+	if mode != PropagateLegacy {
+		if traceId, parentId, flags, ok := parseTraceparent(header.Get(traceparentHeader)); ok {
+			operation.operationId = traceId
+			operation.parentId = parentId
+			operation.traceFlags = flags
+			operation.traceState = strings.Join(header[http.CanonicalHeaderKey(tracestateHeader)], ",")
+			found = true
+		}
+	}
+
+	if mode != PropagateW3C {
+		if !found {
+			requestId := header.Get(requestIdHeader)
+			if operationId, ok := parseRequestId(requestId); ok {
+				operation.operationId = operationId
+				operation.parentId = requestId
+			}
+		}
+
+		operation.baggage = parseCorrelationContext(header[http.CanonicalHeaderKey(correlationContextHeader)])
+	}
+}
+
+// Generates the Id of the telemetry of an incoming request tracked by the
+// specified operation, after its correlation headers have been extracted.
+func newRequestTelemetryId(operation *Operation, mode PropagationMode) string {
+	if mode != PropagateLegacy {
+		return newSpanId()
+	}
+
+	if strings.HasPrefix(operation.parentId, "|") {
+		return appendRequestId(operation.parentId, newSpanId()[:8]+"_")
+	}
+
+	return "|" + operation.operationId + "."
+}
+
+// Generates the Id of the telemetry of a dependency called within the
+// specified operation.  This is sent as the dependency's Request-Id, so
+// that it matches the parent ID reported by a legacy callee.  Otherwise, it
+// is a span-id.
+func newDependencyTelemetryId(operation *Operation, mode PropagationMode) string {
+	if mode == PropagateLegacy && strings.HasPrefix(operation.id, "|") {
+		child := atomic.AddInt32(&operation.children, 1)
+		return appendRequestId(operation.id, strconv.Itoa(int(child))+".")
+	}
+
+	if mode == PropagateLegacy || !isTraceId(operation.operationId) {
+		return formatRequestId(operation.operationId, newSpanId())
+	}
+
+	return newSpanId()
+}
+
+// Writes correlation headers to an outgoing request made as the specified
+// dependency operation.
+func injectCorrelationHeaders(header http.Header, operation *Operation, mode PropagationMode) {
+	if mode != PropagateLegacy && isTraceId(operation.operationId) && !strings.HasPrefix(operation.id, "|") {
+		header.Set(traceparentHeader, formatTraceparent(operation.operationId, operation.id, operation.traceFlags))
+		if operation.traceState != "" {
+			header.Set(tracestateHeader, operation.traceState)
+		}
+	}
+
+	if mode != PropagateW3C {
+		if strings.HasPrefix(operation.id, "|") {
+			header.Set(requestIdHeader, operation.id)
+		} else {
+			header.Set(requestIdHeader, formatRequestId(operation.operationId, operation.id))
+		}
+
+		if len(operation.baggage) > 0 {
+			header.Set(correlationContextHeader, formatCorrelationContext(operation.baggage))
+		}
+	}
+}
+
+// Returns true if the value can be used as a W3C trace-id.
+func isTraceId(value string) bool {
+	return len(value) == 32 && isLowerHex(value) && !isZeroId(value)
+}
+
+func isLowerHex(value string) bool {
+	for _, c := range value {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isZeroId(value string) bool {
+	return strings.Trim(value, "0") == ""
+}
@@ -0,0 +1,237 @@
+package appinsights
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const traceId = "0af7651916cd43dd8448eb211c80319c"
+	const spanId = "b7ad6b7169203331"
+
+	valid := []struct {
+		value string
+		flags byte
+	}{
+		{"00-" + traceId + "-" + spanId + "-01", 0x01},
+		{"00-" + traceId + "-" + spanId + "-00", 0x00},
+		{" 00-" + traceId + "-" + spanId + "-01 ", 0x01},
+		{"cc-" + traceId + "-" + spanId + "-09-future", 0x09},
+	}
+
+	for _, test := range valid {
+		tid, sid, flags, ok := parseTraceparent(test.value)
+		if !ok || tid != traceId || sid != spanId || flags != test.flags {
+			t.Errorf("Failed to parse %q", test.value)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"00-" + traceId + "-" + spanId,
+		"00-" + traceId + "-" + spanId + "-01-extra",
+		"ff-" + traceId + "-" + spanId + "-01",
+		"00-00000000000000000000000000000000-" + spanId + "-01",
+		"00-" + traceId + "-0000000000000000-01",
+		"00-0AF7651916CD43DD8448EB211C80319C-" + spanId + "-01",
+		"00-" + traceId + "-" + spanId + "-0g",
+		"cc-" + traceId + "-" + spanId + "-01future",
+	}
+
+	for _, value := range invalid {
+		if _, _, _, ok := parseTraceparent(value); ok {
+			t.Errorf("Parsed invalid traceparent %q", value)
+		}
+	}
+
+	if value := formatTraceparent(traceId, spanId, traceFlagSampled); value != valid[0].value {
+		t.Errorf("Unexpected traceparent: %q", value)
+	}
+}
+
+func TestNewTraceIds(t *testing.T) {
+	if id := newTraceId(); !isTraceId(id) {
+		t.Errorf("Invalid trace-id: %q", id)
+	}
+
+	if id := newSpanId(); len(id) != 16 || !isLowerHex(id) {
+		t.Errorf("Invalid span-id: %q", id)
+	}
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	var outgoing http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outgoing = r.Header
+	}))
+	defer server.Close()
+
+	httpClient := &http.Client{Transport: NewHttpTransport(client, nil)}
+	handler := NewHttpHandler(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outbound, _ := http.NewRequest("GET", server.URL, nil)
+		response, err := httpClient.Do(outbound.WithContext(r.Context()))
+		if err != nil {
+			t.Fatalf("Outbound request failed: %s", err.Error())
+		}
+
+		response.Body.Close()
+	}))
+
+	request := httptest.NewRequest("GET", "http://localhost/", nil)
+	request.Header.Set(traceparentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	request.Header.Add(tracestateHeader, "congo=t61rcWkgMzE")
+	request.Header.Add(tracestateHeader, "rojo=00f067aa0ba902b7")
+	request.Header.Set(requestIdHeader, "|ignored.1.")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 2 {
+		t.Fatalf("Tracked %d items, want 2", len(items))
+	}
+
+	dep, req := items[0], items[1]
+	reqData := requestData(t, req)
+	depData := dependencyData(t, dep)
+
+	checkDataContract(t, "request ai.operation.id", req.Tags[contracts.OperationId], "0af7651916cd43dd8448eb211c80319c")
+	checkDataContract(t, "request ai.operation.parentId", req.Tags[contracts.OperationParentId], "b7ad6b7169203331")
+	checkDataContract(t, "dependency ai.operation.parentId", dep.Tags[contracts.OperationParentId], reqData.Id)
+
+	checkDataContract(t, "traceparent", outgoing.Get(traceparentHeader), "00-0af7651916cd43dd8448eb211c80319c-"+depData.Id+"-00")
+	checkDataContract(t, "tracestate", outgoing.Get(tracestateHeader), "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7")
+	checkDataContract(t, "Request-Id", outgoing.Get(requestIdHeader), "|0af7651916cd43dd8448eb211c80319c."+depData.Id+".")
+}
+
+func TestLegacyRequestIdFallback(t *testing.T) {
+	operation := &Operation{}
+	header := make(http.Header)
+	header.Set(requestIdHeader, "|abc.1.")
+	extractCorrelationHeaders(header, operation, PropagateW3CAndLegacy)
+
+	checkDataContract(t, "OperationId", operation.OperationId(), "abc")
+	checkDataContract(t, "ParentId", operation.ParentId(), "|abc.1.")
+
+	// Operation IDs that aren't trace-ids can't be sent as traceparent.
+	operation.id = "0123456789abcdef"
+	outgoing := make(http.Header)
+	injectCorrelationHeaders(outgoing, operation, PropagateW3CAndLegacy)
+	checkDataContract(t, "Request-Id", outgoing.Get(requestIdHeader), "|abc.0123456789abcdef.")
+	if value := outgoing.Get(traceparentHeader); value != "" {
+		t.Errorf("Unexpected traceparent: %q", value)
+	}
+}
+
+func TestLegacyPropagation(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	config := NewTelemetryConfiguration(test_ikey)
+	config.Propagation = PropagateLegacy
+	config.ApplicationId = "caller"
+	client, transmitter := newTestChannelServer(config)
+	defer transmitter.Close()
+
+	var outgoing []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outgoing = append(outgoing, r.Header)
+		w.Header().Set(requestContextHeader, "appId=cid-v1:callee")
+	}))
+	defer server.Close()
+
+	httpClient := &http.Client{Transport: NewHttpTransport(client, nil)}
+	handler := NewHttpHandler(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 2; i++ {
+			outbound, _ := http.NewRequest("GET", server.URL, nil)
+			response, err := httpClient.Do(outbound.WithContext(r.Context()))
+			if err != nil {
+				t.Fatalf("Outbound request failed: %s", err.Error())
+			}
+
+			response.Body.Close()
+		}
+	}))
+
+	request := httptest.NewRequest("GET", "http://localhost/", nil)
+	request.Header.Set(traceparentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	request.Header.Set(requestIdHeader, "|root.1.")
+	request.Header.Set(correlationContextHeader, "user=alice, tenant = contoso,bad")
+	request.Header.Set(requestContextHeader, "appId=cid-v1:upstream")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	checkDataContract(t, "response Request-Context", recorder.Header().Get(requestContextHeader), "appId=cid-v1:caller")
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 3 {
+		t.Fatalf("Tracked %d items, want 3", len(items))
+	}
+
+	dep1, dep2, req := dependencyData(t, items[0]), dependencyData(t, items[1]), items[2]
+	reqData := requestData(t, req)
+
+	checkDataContract(t, "request ai.operation.id", req.Tags[contracts.OperationId], "root")
+	checkDataContract(t, "request ai.operation.parentId", req.Tags[contracts.OperationParentId], "|root.1.")
+	checkDataContract(t, "request Source", reqData.Source, "cid-v1:upstream")
+	checkDataContract(t, "request Properties[user]", reqData.Properties["user"], "alice")
+	checkDataContract(t, "request Properties[tenant]", reqData.Properties["tenant"], "contoso")
+	if len(reqData.Id) != len("|root.1.")+9 || reqData.Id[:8] != "|root.1." || reqData.Id[len(reqData.Id)-1] != '_' {
+		t.Errorf("Unexpected request Id: %q", reqData.Id)
+	}
+
+	checkDataContract(t, "dependency 1 Id", dep1.Id, reqData.Id+"1.")
+	checkDataContract(t, "dependency 2 Id", dep2.Id, reqData.Id+"2.")
+	checkDataContract(t, "dependency Type", dep1.Type, "Http (tracked component)")
+	checkDataContract(t, "dependency Target", dep1.Target, server.Listener.Addr().String()+" | cid-v1:callee")
+
+	header := outgoing[0]
+	checkDataContract(t, "Request-Id", header.Get(requestIdHeader), dep1.Id)
+	checkDataContract(t, "Correlation-Context", header.Get(correlationContextHeader), "tenant=contoso, user=alice")
+	checkDataContract(t, "Request-Context", header.Get(requestContextHeader), "appId=cid-v1:caller")
+	if value := header.Get(traceparentHeader); value != "" {
+		t.Errorf("Unexpected traceparent: %q", value)
+	}
+}
+
+func TestW3COnlyPropagation(t *testing.T) {
+	operation := &Operation{}
+	header := make(http.Header)
+	header.Set(requestIdHeader, "|root.1.")
+	header.Set(correlationContextHeader, "user=alice")
+	extractCorrelationHeaders(header, operation, PropagateW3C)
+
+	if operation.OperationId() != "" || operation.ParentId() != "" || operation.baggage != nil {
+		t.Error("Legacy headers should be ignored")
+	}
+
+	operation.operationId = newTraceId()
+	operation.baggage = map[string]string{"user": "alice"}
+	outgoing := make(http.Header)
+	operation.id = newDependencyTelemetryId(operation, PropagateW3C)
+	injectCorrelationHeaders(outgoing, operation, PropagateW3C)
+
+	checkDataContract(t, "traceparent", outgoing.Get(traceparentHeader), formatTraceparent(operation.operationId, operation.id, 0))
+	if outgoing.Get(requestIdHeader) != "" || outgoing.Get(correlationContextHeader) != "" {
+		t.Error("Legacy headers should not be written")
+	}
+}
+
+func TestAppendRequestIdOverflow(t *testing.T) {
+	parent := "|root." + strings.Repeat("1.", (requestIdMaxLength-6)/2)
+	id := appendRequestId(parent, "12345.")
+	if len(id) > requestIdMaxLength || !strings.HasSuffix(id, "#") || !strings.HasPrefix(id, "|root.1.") {
+		t.Errorf("Unexpected overflow Request-Id: %q", id)
+	}
+
+	if id := appendRequestId("|root.", "1."); id != "|root.1." {
+		t.Errorf("Unexpected Request-Id: %q", id)
+	}
+}
@@ -64,8 +64,14 @@ func (writer *diagnosticsMessageWriter) removeListener(listener *diagnosticsMess
 }
 
 func (writer *diagnosticsMessageWriter) Write(message string) {
+	// Copy the listeners, since they may be added or removed while this
+	// writes from another goroutine.
+	writer.lock.Lock()
+	listeners := append([]*diagnosticsMessageListener(nil), writer.listeners...)
+	writer.lock.Unlock()
+
 	var toRemove []*diagnosticsMessageListener
-	for _, listener := range writer.listeners {
+	for _, listener := range listeners {
 		if err := listener.handler(message); err != nil {
 			toRemove = append(toRemove, listener)
 		}
@@ -84,5 +90,7 @@ func (writer *diagnosticsMessageWriter) Printf(message string, args ...interface
 }
 
 func (writer *diagnosticsMessageWriter) hasListeners() bool {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
 	return len(writer.listeners) > 0
 }
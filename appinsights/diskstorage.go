@@ -0,0 +1,271 @@
+package appinsights
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+const (
+	storageFileExtension     = ".trn"
+	storageTempFileExtension = ".tmp"
+)
+
+// Keeps serialized telemetry batches on disk until they have been accepted
+// by the data collector.  Each batch is stored in its own file, named after
+// the time it was created so that files sort oldest-first.
+type diskStorage struct {
+	directory string
+	maxSize   int64
+	maxAge    time.Duration
+
+	// Files that are currently being transmitted, and should not be
+	// picked up for replay.
+	inFlight map[string]bool
+	lock     sync.Mutex
+}
+
+type storedFile struct {
+	name    string
+	size    int64
+	created time.Time
+}
+
+func newDiskStorage(directory string, maxSize int64, maxAge time.Duration) (*diskStorage, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+
+	// An existing directory may still be read-only; find out now rather
+	// than on every save.
+	probe, err := ioutil.TempFile(directory, "probe-*"+storageTempFileExtension)
+	if err != nil {
+		return nil, err
+	}
+
+	probe.Close()
+	os.Remove(probe.Name())
+
+	return &diskStorage{
+		directory: directory,
+		maxSize:   maxSize,
+		maxAge:    maxAge,
+		inFlight:  make(map[string]bool),
+	}, nil
+}
+
+// Writes the payload to a new file and marks it as in-flight.  Returns the
+// name of the file, or an empty string if it could not be written.
+func (storage *diskStorage) save(payload []byte) string {
+	now := currentClock.Now()
+	name := fmt.Sprintf("%019d-%s%s", now.UnixNano(), newUUID().String(), storageFileExtension)
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	if err := storage.write(name, payload); err != nil {
+		diagnosticsWriter.Printf("Failed to write telemetry to storage: %s", err.Error())
+		return ""
+	}
+
+	storage.inFlight[name] = true
+	storage.enforceLimits(now)
+	return name
+}
+
+// Records the outcome of a submission of the named file.  If nothing
+// remains to be sent, the file is deleted.  Otherwise, it is rewritten to
+// hold only the remaining payload and left for a later replay.
+func (storage *diskStorage) settle(name string, count int, payload []byte, items telemetryBufferItems) {
+	if name == "" {
+		return
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	delete(storage.inFlight, name)
+
+	if len(payload) == 0 || len(items) == 0 {
+		storage.remove(name)
+	} else if len(items) < count {
+		if err := storage.write(name, payload); err != nil {
+			diagnosticsWriter.Printf("Failed to update stored telemetry: %s", err.Error())
+		}
+	} else {
+		diagnosticsWriter.Printf("Keeping %d items in storage for later submission", len(items))
+	}
+}
+
+// Marks the named file as in-flight if it exists and is not already being
+// transmitted.  Returns false if the file cannot be claimed.
+func (storage *diskStorage) claim(name string) bool {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	if storage.inFlight[name] {
+		return false
+	}
+
+	if _, err := os.Stat(filepath.Join(storage.directory, name)); err != nil {
+		return false
+	}
+
+	storage.inFlight[name] = true
+	return true
+}
+
+// Reads the named file and parses the telemetry items it contains.  Files
+// that cannot be parsed are deleted.  On error, the file is no longer
+// in-flight.
+func (storage *diskStorage) load(name string) (payload []byte, items telemetryBufferItems, err error) {
+	defer func() {
+		if err != nil {
+			storage.lock.Lock()
+			delete(storage.inFlight, name)
+			storage.lock.Unlock()
+		}
+	}()
+
+	payload, err = ioutil.ReadFile(filepath.Join(storage.directory, name))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, line := range bytes.Split(payload, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+
+		item := &contracts.Envelope{}
+		if err := json.Unmarshal(line, item); err != nil {
+			storage.lock.Lock()
+			storage.remove(name)
+			storage.lock.Unlock()
+
+			return nil, nil, fmt.Errorf("stored telemetry in %s is corrupt: %s", name, err.Error())
+		}
+
+		items = append(items, item)
+	}
+
+	return payload, items, nil
+}
+
+// Lists stored files that are not in-flight, oldest first.  Files that
+// exceed the maximum age are deleted rather than returned.
+func (storage *diskStorage) pending() []string {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	now := currentClock.Now()
+	storage.enforceLimits(now)
+
+	var result []string
+	for _, file := range storage.list() {
+		if !storage.inFlight[file.name] {
+			result = append(result, file.name)
+		}
+	}
+
+	return result
+}
+
+// Deletes files that exceed the maximum age, then the oldest files until
+// the total size is within the limit.  Files that are in-flight are left
+// alone.  Must be called with the lock held.
+func (storage *diskStorage) enforceLimits(now time.Time) {
+	files := storage.list()
+
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+
+	for _, file := range files {
+		if storage.inFlight[file.name] {
+			continue
+		}
+
+		if storage.maxAge > 0 && now.Sub(file.created) > storage.maxAge {
+			diagnosticsWriter.Printf("Discarding stored telemetry older than %s: %s", storage.maxAge, file.name)
+		} else if storage.maxSize > 0 && total > storage.maxSize {
+			diagnosticsWriter.Printf("Telemetry storage exceeds %d bytes, discarding %s", storage.maxSize, file.name)
+		} else {
+			continue
+		}
+
+		storage.remove(file.name)
+		total -= file.size
+	}
+}
+
+// Lists all stored files, oldest first.  Must be called with the lock held.
+func (storage *diskStorage) list() []*storedFile {
+	infos, err := ioutil.ReadDir(storage.directory)
+	if err != nil {
+		diagnosticsWriter.Printf("Failed to read telemetry storage: %s", err.Error())
+		return nil
+	}
+
+	var files []*storedFile
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, storageFileExtension) {
+			continue
+		}
+
+		dash := strings.IndexByte(name, '-')
+		if dash < 0 {
+			continue
+		}
+
+		nanos, err := strconv.ParseInt(name[:dash], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		files = append(files, &storedFile{
+			name:    name,
+			size:    info.Size(),
+			created: time.Unix(0, nanos),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+
+	return files
+}
+
+// Atomically writes the payload to the named file.  Must be called with the
+// lock held.
+func (storage *diskStorage) write(name string, payload []byte) error {
+	path := filepath.Join(storage.directory, name)
+	tmpPath := path + storageTempFileExtension
+
+	if err := ioutil.WriteFile(tmpPath, payload, 0600); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// Deletes the named file.  Must be called with the lock held.
+func (storage *diskStorage) remove(name string) {
+	if err := os.Remove(filepath.Join(storage.directory, name)); err != nil && !os.IsNotExist(err) {
+		diagnosticsWriter.Printf("Failed to remove stored telemetry: %s", err.Error())
+	}
+}
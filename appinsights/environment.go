@@ -0,0 +1,79 @@
+package appinsights
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variables read by NewTelemetryConfigurationFromEnvironment.
+const (
+	connectionStringEnvironmentVariable   = "APPLICATIONINSIGHTS_CONNECTION_STRING"
+	instrumentationKeyEnvironmentVariable = "APPINSIGHTS_INSTRUMENTATIONKEY"
+	maxBatchSizeEnvironmentVariable       = "APPLICATIONINSIGHTS_MAX_BATCH_SIZE"
+	maxBatchIntervalEnvironmentVariable   = "APPLICATIONINSIGHTS_MAX_BATCH_INTERVAL"
+	roleNameEnvironmentVariable           = "APPLICATIONINSIGHTS_ROLE_NAME"
+	roleInstanceEnvironmentVariable       = "APPLICATIONINSIGHTS_ROLE_INSTANCE"
+	disabledEnvironmentVariable           = "APPLICATIONINSIGHTS_DISABLED"
+)
+
+// Creates a new TelemetryConfiguration object from environment variables:
+//
+//	APPLICATIONINSIGHTS_CONNECTION_STRING   Connection string (preferred)
+//	APPINSIGHTS_INSTRUMENTATIONKEY          Instrumentation key
+//	APPLICATIONINSIGHTS_MAX_BATCH_SIZE      MaxBatchSize, e.g. "1024"
+//	APPLICATIONINSIGHTS_MAX_BATCH_INTERVAL  MaxBatchInterval, e.g. "10s"
+//	APPLICATIONINSIGHTS_ROLE_NAME           CloudRoleName
+//	APPLICATIONINSIGHTS_ROLE_INSTANCE       CloudRoleInstance
+//	APPLICATIONINSIGHTS_DISABLED            Disabled, e.g. "true"
+//
+// Unset variables leave the defaults in place.  Returns an error if a value
+// is malformed, or if neither a connection string nor an instrumentation
+// key is set and telemetry is not disabled.
+func NewTelemetryConfigurationFromEnvironment() (*TelemetryConfiguration, error) {
+	disabled := false
+	if value, ok := os.LookupEnv(disabledEnvironmentVariable); ok && value != "" {
+		var err error
+		if disabled, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("%s is not a boolean: %q", disabledEnvironmentVariable, value)
+		}
+	}
+
+	var config *TelemetryConfiguration
+	if connectionString := os.Getenv(connectionStringEnvironmentVariable); connectionString != "" {
+		var err error
+		if config, err = NewTelemetryConfigurationFromConnectionString(connectionString); err != nil {
+			return nil, fmt.Errorf("%s: %s", connectionStringEnvironmentVariable, err.Error())
+		}
+	} else if ikey := os.Getenv(instrumentationKeyEnvironmentVariable); ikey != "" || disabled {
+		config = NewTelemetryConfiguration(ikey)
+	} else {
+		return nil, fmt.Errorf("neither %s nor %s is set", connectionStringEnvironmentVariable, instrumentationKeyEnvironmentVariable)
+	}
+
+	config.Disabled = disabled
+
+	if value := os.Getenv(maxBatchSizeEnvironmentVariable); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("%s is not a positive integer: %q", maxBatchSizeEnvironmentVariable, value)
+		}
+
+		config.MaxBatchSize = size
+	}
+
+	if value := os.Getenv(maxBatchIntervalEnvironmentVariable); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("%s is not a positive duration: %q", maxBatchIntervalEnvironmentVariable, value)
+		}
+
+		config.MaxBatchInterval = interval
+	}
+
+	config.CloudRoleName = os.Getenv(roleNameEnvironmentVariable)
+	config.CloudRoleInstance = os.Getenv(roleInstanceEnvironmentVariable)
+
+	return config, nil
+}
@@ -0,0 +1,157 @@
+package appinsights
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+var environmentVariables = []string{
+	connectionStringEnvironmentVariable,
+	instrumentationKeyEnvironmentVariable,
+	maxBatchSizeEnvironmentVariable,
+	maxBatchIntervalEnvironmentVariable,
+	roleNameEnvironmentVariable,
+	roleInstanceEnvironmentVariable,
+	disabledEnvironmentVariable,
+}
+
+// Replaces the SDK's environment variables with the specified values for
+// the duration of a test.  Returns a function that restores them.
+func setEnvironment(values map[string]string) func() {
+	saved := make(map[string]string)
+	for _, name := range environmentVariables {
+		if value, ok := os.LookupEnv(name); ok {
+			saved[name] = value
+		}
+
+		os.Unsetenv(name)
+	}
+
+	for name, value := range values {
+		os.Setenv(name, value)
+	}
+
+	return func() {
+		for _, name := range environmentVariables {
+			os.Unsetenv(name)
+		}
+
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+func TestConfigurationFromEnvironment(t *testing.T) {
+	defer setEnvironment(map[string]string{
+		connectionStringEnvironmentVariable:   "InstrumentationKey=" + test_ikey + ";IngestionEndpoint=https://localhost:8080",
+		instrumentationKeyEnvironmentVariable: "ignored",
+		maxBatchSizeEnvironmentVariable:       "16",
+		maxBatchIntervalEnvironmentVariable:   "2s",
+		roleNameEnvironmentVariable:           "my-role",
+		roleInstanceEnvironmentVariable:       "my-instance",
+	})()
+
+	config, err := NewTelemetryConfigurationFromEnvironment()
+	if err != nil {
+		t.Fatalf("Failed to read configuration: %s", err.Error())
+	}
+
+	if config.InstrumentationKey != test_ikey {
+		t.Errorf("InstrumentationKey is %s, want %s", config.InstrumentationKey, test_ikey)
+	}
+
+	if config.EndpointUrl != "https://localhost:8080/v2/track" {
+		t.Errorf("EndpointUrl is %s", config.EndpointUrl)
+	}
+
+	if config.MaxBatchSize != 16 {
+		t.Errorf("MaxBatchSize is %d, want 16", config.MaxBatchSize)
+	}
+
+	if config.MaxBatchInterval != 2*time.Second {
+		t.Errorf("MaxBatchInterval is %s, want 2s", config.MaxBatchInterval)
+	}
+
+	if config.Disabled {
+		t.Error("Disabled is true")
+	}
+
+	context := config.setupContext()
+	if role := context.Tags[contracts.CloudRole]; role != "my-role" {
+		t.Errorf("Cloud role is %s, want my-role", role)
+	}
+
+	if instance := context.Tags[contracts.CloudRoleInstance]; instance != "my-instance" {
+		t.Errorf("Cloud role instance is %s, want my-instance", instance)
+	}
+}
+
+func TestConfigurationFromEnvironmentInstrumentationKey(t *testing.T) {
+	defer setEnvironment(map[string]string{
+		instrumentationKeyEnvironmentVariable: test_ikey,
+	})()
+
+	config, err := NewTelemetryConfigurationFromEnvironment()
+	if err != nil {
+		t.Fatalf("Failed to read configuration: %s", err.Error())
+	}
+
+	defaults := NewTelemetryConfiguration(test_ikey)
+	if config.InstrumentationKey != test_ikey || config.EndpointUrl != defaults.EndpointUrl ||
+		config.MaxBatchSize != defaults.MaxBatchSize || config.MaxBatchInterval != defaults.MaxBatchInterval {
+		t.Error("Configuration does not match defaults")
+	}
+
+	if _, ok := config.setupContext().Tags[contracts.CloudRole]; ok {
+		t.Error("Cloud role should not be set")
+	}
+}
+
+func TestConfigurationFromEnvironmentDisabled(t *testing.T) {
+	defer setEnvironment(map[string]string{
+		disabledEnvironmentVariable: "true",
+	})()
+
+	config, err := NewTelemetryConfigurationFromEnvironment()
+	if err != nil {
+		t.Fatalf("Failed to read configuration: %s", err.Error())
+	}
+
+	client := NewTelemetryClientFromConfig(config)
+	defer client.Channel().Stop()
+
+	if client.IsEnabled() {
+		t.Error("Client should be disabled")
+	}
+}
+
+func TestConfigurationFromEnvironmentErrors(t *testing.T) {
+	tests := []struct {
+		values  map[string]string
+		message string
+	}{
+		{map[string]string{}, instrumentationKeyEnvironmentVariable},
+		{map[string]string{connectionStringEnvironmentVariable: "garbage"}, connectionStringEnvironmentVariable},
+		{map[string]string{instrumentationKeyEnvironmentVariable: test_ikey, maxBatchSizeEnvironmentVariable: "lots"}, maxBatchSizeEnvironmentVariable},
+		{map[string]string{instrumentationKeyEnvironmentVariable: test_ikey, maxBatchSizeEnvironmentVariable: "0"}, maxBatchSizeEnvironmentVariable},
+		{map[string]string{instrumentationKeyEnvironmentVariable: test_ikey, maxBatchIntervalEnvironmentVariable: "10"}, maxBatchIntervalEnvironmentVariable},
+		{map[string]string{instrumentationKeyEnvironmentVariable: test_ikey, disabledEnvironmentVariable: "maybe"}, disabledEnvironmentVariable},
+	}
+
+	for _, test := range tests {
+		restore := setEnvironment(test.values)
+		config, err := NewTelemetryConfigurationFromEnvironment()
+		restore()
+
+		if err == nil || config != nil {
+			t.Errorf("Expected error for %v", test.values)
+		} else if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Error %q does not mention %s", err.Error(), test.message)
+		}
+	}
+}
@@ -28,15 +28,21 @@ type ExceptionTelemetry struct {
 // Creates a new exception telemetry item with the specified error and the
 // current callstack. This should be used directly from a function that
 // handles a recover(), or to report an unexpected error return value from
-// a function.
+// a function.  If the error, or one that it wraps, carries the callstack
+// where it was created, that is used instead; see ErrorCallstack.
 func NewExceptionTelemetry(err interface{}) *ExceptionTelemetry {
 	return newExceptionTelemetry(err, 1)
 }
 
 func newExceptionTelemetry(err interface{}, skip int) *ExceptionTelemetry {
+	frames := ErrorCallstack(err)
+	if len(frames) == 0 {
+		frames = GetCallstack(2 + skip)
+	}
+
 	return &ExceptionTelemetry{
 		Error:         err,
-		Frames:        GetCallstack(2 + skip),
+		Frames:        frames,
 		SeverityLevel: Error,
 		BaseTelemetry: BaseTelemetry{
 			Timestamp:  currentClock.Now(),
@@ -49,8 +55,13 @@ func newExceptionTelemetry(err interface{}, skip int) *ExceptionTelemetry {
 	}
 }
 
+// Maximum number of exceptions reported for an error and the errors it
+// wraps.
+const maxExceptionDetails = 32
+
 func (telem *ExceptionTelemetry) TelemetryData() TelemetryData {
 	details := contracts.NewExceptionDetails()
+	details.Id = 1
 	details.HasFullStack = len(telem.Frames) > 0
 	details.ParsedStack = telem.Frames
 
@@ -74,29 +85,156 @@ func (telem *ExceptionTelemetry) TelemetryData() TelemetryData {
 	data := contracts.NewExceptionData()
 	data.SeverityLevel = telem.SeverityLevel
 	data.Exceptions = []*contracts.ExceptionDetails{details}
+	if err, ok := telem.Error.(error); ok {
+		data.Exceptions = appendInnerExceptions(data.Exceptions, err, details.Id)
+	}
+
 	data.Properties = telem.Properties
 	data.Measurements = telem.Measurements
 
 	return data
 }
 
+// Appends ExceptionDetails for the errors wrapped by err, depth-first, each
+// referring to the exception that wraps it by its OuterId.  Errors wrap
+// others by implementing Unwrap() error, Unwrap() []error like those
+// created by errors.Join, or Cause() error like those from
+// github.com/pkg/errors.
+func appendInnerExceptions(exceptions []*contracts.ExceptionDetails, err error, outerId int) []*contracts.ExceptionDetails {
+	for _, inner := range unwrapErrors(err) {
+		if inner == nil {
+			continue
+		}
+
+		if len(exceptions) >= maxExceptionDetails {
+			break
+		}
+
+		details := contracts.NewExceptionDetails()
+		details.Id = len(exceptions) + 1
+		details.OuterId = outerId
+		details.HasFullStack = false
+		details.Message = inner.Error()
+		details.TypeName = reflect.TypeOf(inner).String()
+		if pcs := errorCallers(inner); len(pcs) > 0 {
+			details.ParsedStack = callstackFromPCs(pcs)
+			details.HasFullStack = true
+		}
+
+		exceptions = append(exceptions, details)
+		exceptions = appendInnerExceptions(exceptions, inner, details.Id)
+	}
+
+	return exceptions
+}
+
+// Gets the errors directly wrapped by err, if any.
+func unwrapErrors(err error) []error {
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		return e.Unwrap()
+	case interface{ Unwrap() error }:
+		if inner := e.Unwrap(); inner != nil {
+			return []error{inner}
+		}
+	case interface{ Cause() error }:
+		if inner := e.Cause(); inner != nil {
+			return []error{inner}
+		}
+	}
+
+	return nil
+}
+
+// Gets the callstack carried by the innermost error in err's chain that
+// carries one, or nil if there is none.  Errors carry callstacks by
+// implementing either Callers() []uintptr, or a StackTrace() method that
+// returns a slice of program counters, like those from
+// github.com/pkg/errors.  The chain is followed through Unwrap() error and
+// Cause() error methods.
+func ErrorCallstack(err interface{}) []*contracts.StackFrame {
+	e, ok := err.(error)
+	if !ok {
+		return nil
+	}
+
+	var pcs []uintptr
+	for i := 0; e != nil && i < maxExceptionDetails; i++ {
+		if callers := errorCallers(e); len(callers) > 0 {
+			pcs = callers
+		}
+
+		switch wrapper := e.(type) {
+		case interface{ Unwrap() error }:
+			e = wrapper.Unwrap()
+		case interface{ Cause() error }:
+			e = wrapper.Cause()
+		default:
+			e = nil
+		}
+	}
+
+	if len(pcs) == 0 {
+		return nil
+	}
+
+	return callstackFromPCs(pcs)
+}
+
+// Gets the program counters carried by an error itself, if any.
+func errorCallers(err error) []uintptr {
+	if e, ok := err.(interface{ Callers() []uintptr }); ok {
+		return e.Callers()
+	}
+
+	// StackTrace methods return a package-specific type, so they can
+	// only be found by reflection.
+	method := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !method.IsValid() {
+		return nil
+	}
+
+	methodType := method.Type()
+	if methodType.NumIn() != 0 || methodType.NumOut() != 1 {
+		return nil
+	}
+
+	resultType := methodType.Out(0)
+	if resultType.Kind() != reflect.Slice || resultType.Elem().Kind() != reflect.Uintptr {
+		return nil
+	}
+
+	result := method.Call(nil)[0]
+	pcs := make([]uintptr, result.Len())
+	for i := range pcs {
+		pcs[i] = uintptr(result.Index(i).Uint())
+	}
+
+	return pcs
+}
+
 // Generates a callstack suitable for inclusion in Application Insights
 // exception telemetry for the current goroutine, skipping a number of frames
 // specified by skip.
 func GetCallstack(skip int) []*contracts.StackFrame {
-	var stackFrames []*contracts.StackFrame
-
 	if skip < 0 {
 		skip = 0
 	}
 
 	stack := make([]uintptr, 64+skip)
 	depth := runtime.Callers(skip+1, stack)
-	if depth == 0 {
+	return callstackFromPCs(stack[:depth])
+}
+
+// Converts program counters, as returned by runtime.Callers, into stack
+// frames.
+func callstackFromPCs(pcs []uintptr) []*contracts.StackFrame {
+	var stackFrames []*contracts.StackFrame
+	if len(pcs) == 0 {
 		return stackFrames
 	}
 
-	frames := runtime.CallersFrames(stack[:depth])
+	frames := runtime.CallersFrames(pcs)
 	level := 0
 	for {
 		frame, more := frames.Next()
@@ -2,6 +2,7 @@ package appinsights
 
 import (
 	"fmt"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -55,6 +56,87 @@ func TestExceptionTelemetry(t *testing.T) {
 	checkDataContract(t, "ExceptionDetails.TypeName", exd3.TypeName, "*appinsights.myGoStringer")
 }
 
+type myWrapError struct {
+	msg   string
+	inner error
+}
+
+func (e *myWrapError) Error() string {
+	return e.msg
+}
+
+func (e *myWrapError) Unwrap() error {
+	return e.inner
+}
+
+type myStringError struct{}
+
+func (e *myStringError) Error() string {
+	return "string error"
+}
+
+type myMultiError struct {
+	errs []error
+}
+
+func (e *myMultiError) Error() string {
+	return "multiple errors"
+}
+
+func (e *myMultiError) Unwrap() []error {
+	return e.errs
+}
+
+func TestExceptionChain(t *testing.T) {
+	err := &myWrapError{
+		msg: "outer",
+		inner: &myMultiError{
+			errs: []error{
+				&myWrapError{msg: "first", inner: &myError{}},
+				nil,
+				&myStringError{},
+			},
+		},
+	}
+
+	exceptions := NewExceptionTelemetry(err).TelemetryData().(*contracts.ExceptionData).Exceptions
+	expected := []struct {
+		id, outerId int
+		typeName    string
+		message     string
+	}{
+		{1, 0, "*appinsights.myWrapError", "outer"},
+		{2, 1, "*appinsights.myMultiError", "multiple errors"},
+		{3, 2, "*appinsights.myWrapError", "first"},
+		{4, 3, "*appinsights.myError", "My error error"},
+		{5, 2, "*appinsights.myStringError", "string error"},
+	}
+
+	if len(exceptions) != len(expected) {
+		t.Fatalf("Got %d exceptions, want %d", len(exceptions), len(expected))
+	}
+
+	for i, e := range expected {
+		exd := exceptions[i]
+		checkDataContract(t, "ExceptionDetails.Id", exd.Id, e.id)
+		checkDataContract(t, "ExceptionDetails.OuterId", exd.OuterId, e.outerId)
+		checkDataContract(t, "ExceptionDetails.TypeName", exd.TypeName, e.typeName)
+		checkDataContract(t, "ExceptionDetails.Message", exd.Message, e.message)
+	}
+
+	if !exceptions[0].HasFullStack || exceptions[1].HasFullStack {
+		t.Error("Only the outer exception should have a stack")
+	}
+}
+
+func TestExceptionChainLimit(t *testing.T) {
+	cycle := &myWrapError{msg: "cycle"}
+	cycle.inner = cycle
+
+	exceptions := NewExceptionTelemetry(cycle).TelemetryData().(*contracts.ExceptionData).Exceptions
+	checkDataContract(t, "len(Exceptions)", len(exceptions), maxExceptionDetails)
+}
+
 func TestTrackPanic(t *testing.T) {
 	mockClock()
 	defer resetClock()
@@ -191,3 +273,74 @@ func catchTrackPanic(client TelemetryClient, err interface{}) {
 	defer TrackPanic(client, false)
 	panic(err)
 }
+
+type myCallersError struct {
+	pcs []uintptr
+}
+
+func (e *myCallersError) Error() string {
+	return "callers error"
+}
+
+func (e *myCallersError) Callers() []uintptr {
+	return e.pcs
+}
+
+type myFrame uintptr
+
+type myStackTrace []myFrame
+
+type myStackTraceError struct {
+	stack myStackTrace
+	cause error
+}
+
+func (e *myStackTraceError) Error() string {
+	return "stack trace error"
+}
+
+func (e *myStackTraceError) StackTrace() myStackTrace {
+	return e.stack
+}
+
+func (e *myStackTraceError) Cause() error {
+	return e.cause
+}
+
+func newCallersError() error {
+	pcs := make([]uintptr, 32)
+	return &myCallersError{pcs: pcs[:runtime.Callers(1, pcs)]}
+}
+
+func newStackTraceError(cause error) error {
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(1, pcs)]
+
+	stack := make(myStackTrace, len(pcs))
+	for i, pc := range pcs {
+		stack[i] = myFrame(pc)
+	}
+
+	return &myStackTraceError{stack: stack, cause: cause}
+}
+
+func TestErrorCallstack(t *testing.T) {
+	// The innermost stack is used.
+	err := &myWrapError{msg: "outer", inner: newStackTraceError(newCallersError())}
+	exceptions := NewExceptionTelemetry(err).TelemetryData().(*contracts.ExceptionData).Exceptions
+	checkDataContract(t, "len(Exceptions)", len(exceptions), 3)
+	checkDataContract(t, "outer Method", exceptions[0].ParsedStack[0].Method, "newCallersError")
+
+	// Inner exceptions carry their own stacks.
+	checkDataContract(t, "inner Method", exceptions[1].ParsedStack[0].Method, "newStackTraceError")
+	checkDataContract(t, "inner HasFullStack", exceptions[1].HasFullStack, true)
+	checkDataContract(t, "innermost Method", exceptions[2].ParsedStack[0].Method, "newCallersError")
+
+	// Errors without a stack fall back to the current callstack.
+	exceptions = NewExceptionTelemetry(&myError{}).TelemetryData().(*contracts.ExceptionData).Exceptions
+	checkDataContract(t, "fallback Method", exceptions[0].ParsedStack[0].Method, "TestErrorCallstack")
+
+	if frames := ErrorCallstack("not an error"); frames != nil {
+		t.Error("Unexpected callstack for a string")
+	}
+}
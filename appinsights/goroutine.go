@@ -0,0 +1,170 @@
+package appinsights
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Name of the property that records where a goroutine started by a
+// PanicReporter was created.
+const goroutineCreatedByProperty = "createdBy"
+
+// Runs functions in new goroutines, and reports any panic that escapes them
+// as ExceptionTelemetry with Critical severity.  The exception is flushed
+// to the data collector before the goroutine exits, since the program may
+// be about to crash.
+type PanicReporter struct {
+	// If set, the panic continues once it has been reported, which
+	// crashes the program as it would have without the PanicReporter.
+	// The exception is then submitted as by TrackCrash.
+	Rethrow bool
+
+	// Maximum time to wait for the exception to be submitted.
+	FlushTimeout time.Duration
+
+	client  TelemetryClient
+	running sync.WaitGroup
+}
+
+// Channels that can report when a flush has completed.
+type flushCallbackChannel interface {
+	flushCallback(immediate bool) <-chan struct{}
+}
+
+// Creates a PanicReporter that reports to the specified client and does
+// not rethrow panics.
+func NewPanicReporter(client TelemetryClient) *PanicReporter {
+	return &PanicReporter{
+		FlushTimeout: time.Duration(5) * time.Second,
+		client:       client,
+	}
+}
+
+// Runs f in a new goroutine, and reports any panic to the specified client.
+// The panic does not continue.  See PanicReporter.
+func Go(client TelemetryClient, f func()) {
+	NewPanicReporter(client).start(backgroundContext, callerSite(1), func(context.Context) { f() })
+}
+
+// Runs f with ctx in a new goroutine, and reports any panic to the
+// specified client, correlated with the operation in ctx.  The panic does
+// not continue.  See PanicReporter.
+func GoCtx(ctx context.Context, client TelemetryClient, f func(context.Context)) {
+	NewPanicReporter(client).start(ctx, callerSite(1), f)
+}
+
+// Runs f in a new goroutine, and reports any panic.
+func (reporter *PanicReporter) Go(f func()) {
+	reporter.start(backgroundContext, callerSite(1), func(context.Context) { f() })
+}
+
+// Runs f with ctx in a new goroutine, and reports any panic, correlated
+// with the operation in ctx.
+func (reporter *PanicReporter) GoCtx(ctx context.Context, f func(context.Context)) {
+	reporter.start(ctx, callerSite(1), f)
+}
+
+// Waits until every goroutine started by this PanicReporter has finished,
+// and any panics they raised have been reported.  Call it before exiting
+// the program so that the reports are not lost.
+func (reporter *PanicReporter) Wait() {
+	reporter.running.Wait()
+}
+
+func (reporter *PanicReporter) start(ctx context.Context, site string, f func(context.Context)) {
+	reporter.running.Add(1)
+	go func() {
+		defer reporter.running.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				reporter.report(ctx, site, r)
+				if reporter.Rethrow {
+					panic(r)
+				}
+			}
+		}()
+
+		f(ctx)
+	}()
+}
+
+// Tracks a recovered panic and waits for it to be submitted.
+func (reporter *PanicReporter) report(ctx context.Context, site string, r interface{}) {
+	exception := newExceptionTelemetry(r, 2)
+	exception.SeverityLevel = Critical
+	if site != "" {
+		exception.Properties[goroutineCreatedByProperty] = site
+	}
+
+	reporter.client.TrackWithContext(ctx, exception)
+	if !flushAndWait(reporter.client.Channel(), reporter.FlushTimeout, reporter.Rethrow) {
+		diagnosticsWriter.Printf("Timed out after %s waiting for panic to be submitted", reporter.FlushTimeout)
+	}
+}
+
+// Recovers from any active panic, tracks it with Critical severity, and
+// panics again once it has been submitted, so that the program crashes as
+// it would have otherwise.  The exception and any other buffered telemetry
+// are transmitted immediately, without waiting for the batch interval or
+// for the channel to stop being throttled, and are not retried if they
+// fail.  TrackCrash waits at most timeout for the transmission to complete.
+// Should be invoked via defer at the top of main and of goroutines.
+func TrackCrash(client TelemetryClient, timeout time.Duration) {
+	if r := recover(); r != nil {
+		exception := newExceptionTelemetry(r, 1)
+		exception.SeverityLevel = Critical
+		client.Track(exception)
+
+		if !flushAndWait(client.Channel(), timeout, true) {
+			diagnosticsWriter.Printf("Timed out after %s waiting for crash to be submitted", timeout)
+		}
+
+		panic(r)
+	}
+}
+
+// Flushes the channel and waits up to timeout for the submission to
+// complete.  Returns false if the timeout expired first.  If immediate is
+// set, buffered telemetry is submitted once, even if the channel is
+// throttled.  Channels that cannot report completion are flushed without
+// waiting.
+func flushAndWait(channel TelemetryChannel, timeout time.Duration, immediate bool) bool {
+	flusher, ok := channel.(flushCallbackChannel)
+	if !ok {
+		channel.Flush()
+		return true
+	}
+
+	done := flusher.flushCallback(immediate)
+	if done == nil {
+		return true
+	}
+
+	timer := currentClock.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C():
+		return false
+	}
+}
+
+// Describes the site of a function call, skipping the specified number of
+// frames above the caller of callerSite.
+func callerSite(skip int) string {
+	pc, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return ""
+	}
+
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		return fmt.Sprintf("%s at %s:%d", fn.Name(), file, line)
+	}
+
+	return fmt.Sprintf("%s:%d", file, line)
+}
@@ -0,0 +1,239 @@
+package appinsights
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func TestGoReportsPanic(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+	defer client.Channel().Stop()
+
+	ctx, operation := client.StartRequestOperation(context.Background(), "op")
+	reporter := NewPanicReporter(client)
+	reporter.GoCtx(ctx, func(ctx context.Context) {
+		panic("~boom~")
+	})
+
+	// Flushed without waiting for the batch interval.
+	req := transmitter.waitForRequest(t)
+	transmitter.prepResponse(200)
+	reporter.Wait()
+
+	if len(req.items) != 1 {
+		t.Fatalf("Tracked %d items, want 1", len(req.items))
+	}
+
+	envelope := req.items[0]
+	data := envelope.Data.(*contracts.Data).BaseData.(*contracts.ExceptionData)
+	checkDataContract(t, "SeverityLevel", data.SeverityLevel, Critical)
+	checkDataContract(t, "Message", data.Exceptions[0].Message, "~boom~")
+	checkDataContract(t, "ai.operation.parentId", envelope.Tags[contracts.OperationParentId], operation.Id())
+
+	createdBy := data.Properties[goroutineCreatedByProperty]
+	if !strings.Contains(createdBy, "TestGoReportsPanic") || !strings.Contains(createdBy, "goroutine_test.go:") {
+		t.Errorf("Unexpected creation site: %q", createdBy)
+	}
+}
+
+func TestGoWithoutPanic(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+	defer client.Channel().Stop()
+
+	done := make(chan struct{})
+	Go(client, func() {
+		close(done)
+	})
+
+	<-done
+	transmitter.assertNoRequest(t)
+}
+
+func TestPanicReporterFlushTimeout(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+	defer client.Channel().Stop()
+
+	timedOut := make(chan struct{}, 1)
+	NewDiagnosticsMessageListener(func(msg string) error {
+		if strings.Contains(msg, "waiting for panic to be submitted") {
+			timedOut <- struct{}{}
+		}
+
+		return nil
+	})
+	defer resetDiagnosticsListeners()
+
+	reporter := NewPanicReporter(client)
+	reporter.FlushTimeout = time.Second
+	reporter.Go(func() {
+		panic("~boom~")
+	})
+
+	// Never respond; the reporter gives up once the timeout expires.
+	transmitter.waitForRequest(t)
+	for i := 0; i < 100; i++ {
+		select {
+		case <-timedOut:
+			reporter.Wait()
+			transmitter.prepResponse(200)
+			return
+		default:
+			slowTick(1)
+		}
+	}
+
+	t.Fatal("Reporter did not time out")
+}
+
+// Runs f in a goroutine under TrackCrash, and returns a channel that
+// receives the value recovered from the re-panic.
+func crashInGoroutine(client TelemetryClient, timeout time.Duration, f func()) <-chan interface{} {
+	result := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			result <- recover()
+		}()
+		defer TrackCrash(client, timeout)
+		f()
+	}()
+
+	return result
+}
+
+func TestTrackCrash(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+	defer client.Channel().Stop()
+
+	tm := currentClock.Now()
+	client.TrackTrace("~msg~", Information)
+	result := crashInGoroutine(client, time.Minute, func() {
+		panic("~crash~")
+	})
+
+	// Sent without waiting for the batch interval.
+	req := transmitter.waitForRequest(t)
+	assertTimeApprox(t, req.timestamp, tm)
+	transmitter.prepResponse(200)
+
+	if r := <-result; r != "~crash~" {
+		t.Errorf("Re-panicked with %v, want ~crash~", r)
+	}
+
+	if len(req.items) != 2 || !strings.Contains(req.payload, "~msg~") {
+		t.Fatalf("Unexpected request: %s", req.payload)
+	}
+
+	data := req.items[1].Data.(*contracts.Data).BaseData.(*contracts.ExceptionData)
+	checkDataContract(t, "SeverityLevel", data.SeverityLevel, Critical)
+	checkDataContract(t, "Message", data.Exceptions[0].Message, "~crash~")
+}
+
+func TestTrackCrashWithoutPanic(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+	defer client.Channel().Stop()
+
+	if r := <-crashInGoroutine(client, time.Minute, func() {}); r != nil {
+		t.Errorf("Unexpected panic: %v", r)
+	}
+
+	transmitter.assertNoRequest(t)
+}
+
+func TestTrackCrashWhileThrottled(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+	defer client.Channel().Stop()
+
+	retryAfter := transmitter.prepThrottle(time.Minute)
+	client.TrackTrace("~throttled~", Information)
+	slowTick(10)
+	transmitter.waitForRequest(t)
+	slowTick(1)
+
+	result := crashInGoroutine(client, time.Minute, func() {
+		panic("~crash~")
+	})
+
+	req := transmitter.waitForRequest(t)
+	if !req.timestamp.Before(retryAfter) {
+		t.Error("Crash was not sent until throttle expired")
+	}
+
+	if len(req.items) != 1 || !strings.Contains(req.payload, "~crash~") {
+		t.Fatalf("Unexpected request: %s", req.payload)
+	}
+
+	transmitter.prepResponse(200)
+	if r := <-result; r != "~crash~" {
+		t.Errorf("Re-panicked with %v, want ~crash~", r)
+	}
+
+	// The throttled item is still retried afterwards.
+	transmitter.prepResponse(200)
+	slowTick(60)
+	req = transmitter.waitForRequest(t)
+	if !strings.Contains(req.payload, "~throttled~") {
+		t.Errorf("Unexpected request: %s", req.payload)
+	}
+}
+
+func TestTrackCrashTimeout(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+	defer client.Channel().Stop()
+
+	timedOut := make(chan struct{}, 1)
+	NewDiagnosticsMessageListener(func(msg string) error {
+		if strings.Contains(msg, "waiting for crash to be submitted") {
+			timedOut <- struct{}{}
+		}
+
+		return nil
+	})
+	defer resetDiagnosticsListeners()
+
+	result := crashInGoroutine(client, time.Second, func() {
+		panic("~crash~")
+	})
+
+	// Never respond; the panic continues once the timeout expires.
+	transmitter.waitForRequest(t)
+	for i := 0; i < 100; i++ {
+		select {
+		case <-timedOut:
+			if r := <-result; r != "~crash~" {
+				t.Errorf("Re-panicked with %v, want ~crash~", r)
+			}
+
+			transmitter.prepResponse(200)
+			return
+		default:
+			slowTick(1)
+		}
+	}
+
+	t.Fatal("TrackCrash did not time out")
+}
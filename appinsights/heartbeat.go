@@ -0,0 +1,152 @@
+package appinsights
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Name of the metric submitted by HeartbeatProvider, which the
+	// portal uses to tell whether an instance of the application is
+	// alive.
+	heartbeatMetricName = "HeartbeatState"
+
+	// Default interval between heartbeats.
+	defaultHeartbeatInterval = time.Duration(15) * time.Minute
+
+	// Property that reports whether the most recent transmission of
+	// telemetry succeeded.
+	heartbeatTransmissionProperty = "transmissionSucceeded"
+)
+
+// When the process started, approximately.
+var processStartTime = time.Now()
+
+// Periodically submits a HeartbeatState metric that carries information
+// about the environment as properties: the Go version, operating system and
+// architecture, SDK version, process start time, whether the most recent
+// transmission of telemetry succeeded, and any custom fields.  The metric's
+// value is the number of fields that are unhealthy, so zero indicates that
+// all is well.
+type HeartbeatProvider struct {
+	client   TelemetryClient
+	interval time.Duration
+	lock     sync.Mutex
+	fields   map[string]heartbeatField
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type heartbeatField struct {
+	value   string
+	healthy bool
+}
+
+// Creates a HeartbeatProvider that submits to the specified client, sends a
+// heartbeat immediately, and then sends one every interval in a background
+// goroutine.  If interval is zero, it defaults to 15 minutes.  The provider
+// stops when the client's channel is closed or stopped.
+func NewHeartbeatProvider(client TelemetryClient, interval time.Duration) *HeartbeatProvider {
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+
+	provider := &HeartbeatProvider{
+		client:   client,
+		interval: interval,
+		fields:   make(map[string]heartbeatField),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	provider.setDefaultFields()
+
+	if channel, ok := client.Channel().(closeHookChannel); ok {
+		channel.onClose(provider.Stop)
+	}
+
+	go provider.heartbeatLoop()
+
+	return provider
+}
+
+func (provider *HeartbeatProvider) setDefaultFields() {
+	provider.SetField("goVersion", runtime.Version(), true)
+	provider.SetField("os", runtime.GOOS, true)
+	provider.SetField("arch", runtime.GOARCH, true)
+	provider.SetField("sdkVersion", sdkName+":"+Version, true)
+	provider.SetField("processStartTime", processStartTime.UTC().Format(time.RFC3339), true)
+}
+
+// Sets a custom field that is sent with every subsequent heartbeat.  Each
+// field that is not healthy adds one to the heartbeat's value.
+func (provider *HeartbeatProvider) SetField(name, value string, healthy bool) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	provider.fields[name] = heartbeatField{value: value, healthy: healthy}
+}
+
+// Removes a field from subsequent heartbeats.
+func (provider *HeartbeatProvider) RemoveField(name string) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	delete(provider.fields, name)
+}
+
+// Stops sending heartbeats.  Called automatically when the client's
+// channel is closed or stopped.
+func (provider *HeartbeatProvider) Stop() {
+	provider.stopOnce.Do(func() {
+		close(provider.stop)
+		<-provider.done
+	})
+}
+
+func (provider *HeartbeatProvider) heartbeatLoop() {
+	defer close(provider.done)
+
+	timer := currentClock.NewTimer(provider.interval)
+	defer timer.Stop()
+
+	for {
+		provider.client.Track(provider.newHeartbeat())
+
+		select {
+		case <-provider.stop:
+			return
+		case <-timer.C():
+			timer.Reset(provider.interval)
+		}
+	}
+}
+
+// Creates a heartbeat with the current fields.
+func (provider *HeartbeatProvider) newHeartbeat() *MetricTelemetry {
+	heartbeat := NewMetricTelemetry(heartbeatMetricName, 0.0)
+	heartbeat.Tags.Operation().SetSyntheticSource(heartbeatMetricName)
+
+	unhealthy := 0
+	provider.lock.Lock()
+	for name, field := range provider.fields {
+		heartbeat.Properties[name] = field.value
+		if !field.healthy {
+			unhealthy++
+		}
+	}
+	provider.lock.Unlock()
+
+	if channel, ok := provider.client.Channel().(transmitStatusChannel); ok {
+		if succeeded, ok := channel.lastTransmitSucceeded(); ok {
+			heartbeat.Properties[heartbeatTransmissionProperty] = strconv.FormatBool(succeeded)
+			if !succeeded {
+				unhealthy++
+			}
+		}
+	}
+
+	heartbeat.Value = float64(unhealthy)
+	return heartbeat
+}
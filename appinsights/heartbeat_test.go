@@ -0,0 +1,92 @@
+package appinsights
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func heartbeatData(t *testing.T, envelope *contracts.Envelope) (*contracts.MetricData, contracts.ContextTags) {
+	data, ok := envelope.Data.(*contracts.Data).BaseData.(*contracts.MetricData)
+	if !ok || data.Metrics[0].Name != heartbeatMetricName {
+		t.Fatalf("Expected heartbeat, got %s", envelope.Name)
+	}
+
+	return data, contracts.ContextTags(envelope.Tags)
+}
+
+func TestHeartbeatProvider(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	provider := NewHeartbeatProvider(client, time.Minute)
+
+	// The first heartbeat is sent right away.
+	transmitter.prepResponse(400)
+	slowTick(10)
+	req := transmitter.waitForRequest(t)
+	if len(req.items) != 1 {
+		t.Fatalf("Tracked %d items, want 1", len(req.items))
+	}
+
+	data, tags := heartbeatData(t, req.items[0])
+	checkDataContract(t, "Value", data.Metrics[0].Value, 0.0)
+	checkDataContract(t, "SyntheticSource", tags.Operation().GetSyntheticSource(), heartbeatMetricName)
+	checkDataContract(t, "goVersion", data.Properties["goVersion"], runtime.Version())
+	checkDataContract(t, "os", data.Properties["os"], runtime.GOOS)
+	checkDataContract(t, "arch", data.Properties["arch"], runtime.GOARCH)
+	checkDataContract(t, "sdkVersion", data.Properties["sdkVersion"], sdkName+":"+Version)
+	checkNotNullOrEmpty(t, "processStartTime", data.Properties["processStartTime"])
+	if _, ok := data.Properties[heartbeatTransmissionProperty]; ok {
+		t.Error("Transmission status reported before any transmission")
+	}
+
+	// The next one reports the failed transmission and custom fields.
+	provider.SetField("database", "unreachable", false)
+	provider.SetField("~removed~", "value", true)
+	provider.RemoveField("~removed~")
+	slowTick(50)
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 1 {
+		t.Fatalf("Tracked %d items, want 1", len(items))
+	}
+
+	data, _ = heartbeatData(t, items[0])
+	checkDataContract(t, "Value", data.Metrics[0].Value, 2.0)
+	checkDataContract(t, "database", data.Properties["database"], "unreachable")
+	checkDataContract(t, heartbeatTransmissionProperty, data.Properties[heartbeatTransmissionProperty], "false")
+	if _, ok := data.Properties["~removed~"]; ok {
+		t.Error("Removed field was sent")
+	}
+
+	// Closing the channel stops the provider.
+	select {
+	case <-provider.done:
+	default:
+		t.Error("Provider is still running")
+	}
+}
+
+func TestHeartbeatAfterSuccess(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	provider := NewHeartbeatProvider(client, time.Minute)
+	transmitter.prepResponse(200)
+	slowTick(10)
+	transmitter.waitForRequest(t)
+	slowTick(50)
+	provider.Stop()
+
+	items := closeAndGetItems(t, client, transmitter)
+	data, _ := heartbeatData(t, items[0])
+	checkDataContract(t, "Value", data.Metrics[0].Value, 0.0)
+	checkDataContract(t, heartbeatTransmissionProperty, data.Properties[heartbeatTransmissionProperty], "true")
+}
@@ -0,0 +1,233 @@
+package appinsights
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// An http.Handler that tracks each request served by the wrapped handler as
+// RequestTelemetry.  If the caller sent correlation headers, the request is
+// tracked as part of the caller's operation; see PropagationMode.  The
+// request's Operation is available to the wrapped handler through
+// OperationFromContext(request.Context()); telemetry tracked through it is
+// correlated with the request.  Panics are tracked
+// as exceptions and then allowed to continue.
+type HttpHandler struct {
+	client  TelemetryClient
+	handler http.Handler
+}
+
+// Creates an HttpHandler that serves requests with handler and tracks them
+// to the specified client.
+func NewHttpHandler(client TelemetryClient, handler http.Handler) *HttpHandler {
+	return &HttpHandler{
+		client:  client,
+		handler: handler,
+	}
+}
+
+// Serves the request with the wrapped handler and tracks it.
+func (h *HttpHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	name := request.Method + " " + request.URL.Path
+
+	telem := NewRequestTelemetry(request.Method, requestUrl(request), 0, "")
+	telem.Name = name
+
+	if ip := remoteIp(request); ip != "" {
+		telem.Source = ip
+		telem.Tags.Location().SetIp(ip)
+	}
+
+	context := h.client.Context()
+	operation := newOperation(h.client, nil, telem, name)
+	extractCorrelationHeaders(request.Header, operation, context.Propagation)
+
+	operation.id = newRequestTelemetryId(operation, context.Propagation)
+	telem.Id = operation.id
+
+	for k, v := range operation.baggage {
+		if _, ok := telem.Properties[k]; !ok {
+			telem.Properties[k] = v
+		}
+	}
+
+	if appId := requestContextAppId(request.Header); isOtherAppId(appId, context) {
+		telem.Source = appId
+	}
+
+	setRequestContextAppId(writer.Header(), context)
+
+	wrapped, recorder := wrapResponseWriter(writer)
+
+	defer func() {
+		r := recover()
+		code := recorder.statusCode()
+
+		if r != nil {
+			if r != http.ErrAbortHandler {
+				operation.Track(newExceptionTelemetry(r, 1))
+			}
+
+			code = http.StatusInternalServerError
+		}
+
+		telem.ResponseCode = strconv.Itoa(code)
+		telem.Success = r == nil && isSuccessResponseCode(code)
+		operation.End()
+
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	h.handler.ServeHTTP(wrapped, request.WithContext(contextWithOperation(request.Context(), operation)))
+}
+
+// Reconstructs the full URL of an incoming request.
+func requestUrl(request *http.Request) string {
+	if request.URL.IsAbs() {
+		return request.URL.String()
+	}
+
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + request.Host + request.URL.RequestURI()
+}
+
+// Gets the IP address of the caller.
+func remoteIp(request *http.Request) string {
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		return host
+	}
+
+	return request.RemoteAddr
+}
+
+// Wraps an http.ResponseWriter to record the status code written to it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *responseRecorder) WriteHeader(status int) {
+	// Informational responses such as 103 Early Hints precede the final
+	// status, except for 101 Switching Protocols.
+	if recorder.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		recorder.status = status
+	}
+
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *responseRecorder) Write(b []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	return recorder.ResponseWriter.Write(b)
+}
+
+// Gets the original writer, so that http.ResponseController can reach it.
+func (recorder *responseRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
+
+func (recorder *responseRecorder) statusCode() int {
+	if recorder.status == 0 {
+		return http.StatusOK
+	}
+
+	return recorder.status
+}
+
+type responseFlusher struct{ *responseRecorder }
+
+func (flusher responseFlusher) Flush() {
+	if flusher.status == 0 {
+		flusher.status = http.StatusOK
+	}
+
+	flusher.ResponseWriter.(http.Flusher).Flush()
+}
+
+type responseHijacker struct{ *responseRecorder }
+
+func (hijacker responseHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijacker.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && hijacker.status == 0 {
+		hijacker.status = http.StatusSwitchingProtocols
+	}
+
+	return conn, rw, err
+}
+
+type responsePusher struct{ *responseRecorder }
+
+func (pusher responsePusher) Push(target string, opts *http.PushOptions) error {
+	return pusher.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+// Wraps an http.ResponseWriter in a responseRecorder.  The returned writer
+// implements http.Flusher, http.Hijacker and http.Pusher if and only if the
+// original does, and always has an Unwrap method.
+func wrapResponseWriter(writer http.ResponseWriter) (http.ResponseWriter, *responseRecorder) {
+	recorder := &responseRecorder{ResponseWriter: writer}
+
+	_, isFlusher := writer.(http.Flusher)
+	_, isHijacker := writer.(http.Hijacker)
+	_, isPusher := writer.(http.Pusher)
+
+	flusher := responseFlusher{recorder}
+	hijacker := responseHijacker{recorder}
+	pusher := responsePusher{recorder}
+
+	switch {
+	case isFlusher && isHijacker && isPusher:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{recorder, flusher, hijacker, pusher}, recorder
+	case isFlusher && isHijacker:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Hijacker
+		}{recorder, flusher, hijacker}, recorder
+	case isFlusher && isPusher:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Pusher
+		}{recorder, flusher, pusher}, recorder
+	case isHijacker && isPusher:
+		return struct {
+			*responseRecorder
+			http.Hijacker
+			http.Pusher
+		}{recorder, hijacker, pusher}, recorder
+	case isFlusher:
+		return struct {
+			*responseRecorder
+			http.Flusher
+		}{recorder, flusher}, recorder
+	case isHijacker:
+		return struct {
+			*responseRecorder
+			http.Hijacker
+		}{recorder, hijacker}, recorder
+	case isPusher:
+		return struct {
+			*responseRecorder
+			http.Pusher
+		}{recorder, pusher}, recorder
+	default:
+		return recorder, recorder
+	}
+}
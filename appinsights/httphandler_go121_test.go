@@ -0,0 +1,31 @@
+//go:build go1.21
+// +build go1.21
+
+package appinsights
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHttpHandlerResponseController(t *testing.T) {
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	server := httptest.NewServer(NewHttpHandler(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Minute)); err != nil {
+			t.Errorf("SetWriteDeadline failed: %s", err.Error())
+		}
+	})))
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err.Error())
+	}
+
+	response.Body.Close()
+	closeAndGetItems(t, client, transmitter)
+}
@@ -0,0 +1,203 @@
+package appinsights
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Closes the client's channel and returns the telemetry it submitted.
+func closeAndGetItems(t *testing.T, client TelemetryClient, transmitter *testTransmitter) telemetryBufferItems {
+	transmitter.prepResponse(200)
+	waitForClose(t, client.Channel().Close())
+	return transmitter.waitForRequest(t).items
+}
+
+func requestData(t *testing.T, envelope *contracts.Envelope) *contracts.RequestData {
+	data, ok := envelope.Data.(*contracts.Data).BaseData.(*contracts.RequestData)
+	if !ok {
+		t.Fatalf("Expected request telemetry, got %s", envelope.Name)
+	}
+
+	return data
+}
+
+func TestHttpHandler(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	var operation *Operation
+	handler := NewHttpHandler(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation = OperationFromContext(r.Context())
+		operation.Track(NewTraceTelemetry("~child~", Information))
+		operation.Telemetry().GetProperties()["custom"] = "value"
+		fakeClock.Increment(time.Second)
+		w.WriteHeader(404)
+		w.WriteHeader(500)
+	}))
+
+	request := httptest.NewRequest("GET", "http://localhost:8080/path/to?query=1", nil)
+	request.RemoteAddr = "10.0.0.1:12345"
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 2 {
+		t.Fatalf("Tracked %d items, want 2", len(items))
+	}
+
+	child, req := items[0], items[1]
+	data := requestData(t, req)
+
+	checkDataContract(t, "Name", data.Name, "GET /path/to")
+	checkDataContract(t, "Url", data.Url, "http://localhost:8080/path/to?query=1")
+	checkDataContract(t, "ResponseCode", data.ResponseCode, "404")
+	checkDataContract(t, "Success", data.Success, false)
+	checkDataContract(t, "Source", data.Source, "10.0.0.1")
+	checkDataContract(t, "Duration", data.Duration, "0.00:00:01.0000000")
+	checkDataContract(t, "Id", data.Id, operation.Id())
+	checkDataContract(t, "Properties[custom]", data.Properties["custom"], "value")
+
+	checkDataContract(t, "request ai.operation.id", req.Tags[contracts.OperationId], operation.OperationId())
+	checkDataContract(t, "request ai.operation.name", req.Tags[contracts.OperationName], "GET /path/to")
+	checkDataContract(t, "request ai.location.ip", req.Tags[contracts.LocationIp], "10.0.0.1")
+	if _, ok := req.Tags[contracts.OperationParentId]; ok {
+		t.Error("Root request should not have a parent")
+	}
+
+	checkDataContract(t, "child ai.operation.id", child.Tags[contracts.OperationId], operation.OperationId())
+	checkDataContract(t, "child ai.operation.parentId", child.Tags[contracts.OperationParentId], operation.Id())
+	checkDataContract(t, "child ai.operation.name", child.Tags[contracts.OperationName], "GET /path/to")
+}
+
+func TestHttpHandlerDefaultStatus(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	handler := NewHttpHandler(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+
+	items := closeAndGetItems(t, client, transmitter)
+	data := requestData(t, items[0])
+	checkDataContract(t, "ResponseCode", data.ResponseCode, "200")
+	checkDataContract(t, "Success", data.Success, true)
+	checkDataContract(t, "Name", data.Name, "POST /")
+}
+
+func TestHttpHandlerInformationalStatus(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	handler := NewHttpHandler(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(103)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	items := closeAndGetItems(t, client, transmitter)
+	checkDataContract(t, "ResponseCode", requestData(t, items[0]).ResponseCode, "204")
+}
+
+func TestHttpHandlerPanic(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	handler := NewHttpHandler(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("~panic~")
+	}))
+
+	func() {
+		defer func() {
+			if r := recover(); r != "~panic~" {
+				t.Errorf("Panic was not propagated: %v", r)
+			}
+		}()
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 2 {
+		t.Fatalf("Tracked %d items, want 2", len(items))
+	}
+
+	exception := items[0].Data.(*contracts.Data).BaseData.(*contracts.ExceptionData)
+	checkDataContract(t, "Message", exception.Exceptions[0].Message, "~panic~")
+
+	data := requestData(t, items[1])
+	checkDataContract(t, "ResponseCode", data.ResponseCode, "500")
+	checkDataContract(t, "Success", data.Success, false)
+	checkDataContract(t, "exception ai.operation.parentId", items[0].Tags[contracts.OperationParentId], data.Id)
+}
+
+type testHijackWriter struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (writer *testHijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	writer.hijacked = true
+	return nil, nil, nil
+}
+
+func TestHttpHandlerWriterInterfaces(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	// ResponseRecorder is a Flusher, but neither a Hijacker nor Pusher.
+	recorder := httptest.NewRecorder()
+	NewHttpHandler(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Hijacker); ok {
+			t.Error("Writer should not be a Hijacker")
+		}
+
+		if _, ok := w.(http.Pusher); ok {
+			t.Error("Writer should not be a Pusher")
+		}
+
+		if unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok || unwrapper.Unwrap() != recorder {
+			t.Error("Writer does not unwrap to the original")
+		}
+
+		w.(http.Flusher).Flush()
+	})).ServeHTTP(recorder, httptest.NewRequest("GET", "/flush", nil))
+
+	if !recorder.Flushed {
+		t.Error("Flush was not passed through")
+	}
+
+	hijackWriter := &testHijackWriter{ResponseRecorder: httptest.NewRecorder()}
+	NewHttpHandler(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok || unwrapper.Unwrap() != hijackWriter {
+			t.Error("Writer does not unwrap to the original")
+		}
+
+		w.(http.Hijacker).Hijack()
+	})).ServeHTTP(hijackWriter, httptest.NewRequest("GET", "/hijack", nil))
+
+	if !hijackWriter.hijacked {
+		t.Error("Hijack was not passed through")
+	}
+
+	items := closeAndGetItems(t, client, transmitter)
+	checkDataContract(t, "flush ResponseCode", requestData(t, items[0]).ResponseCode, "200")
+	checkDataContract(t, "hijack ResponseCode", requestData(t, items[1]).ResponseCode, "101")
+}
@@ -0,0 +1,89 @@
+package appinsights
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// An http.RoundTripper that tracks each outgoing request as
+// RemoteDependencyTelemetry.  If the request's context carries an
+// Operation, the dependency is tracked as part of it.  Correlation headers
+// are added to the request so that the service being called can correlate
+// its own telemetry with the caller's; see PropagationMode.
+type HttpTransport struct {
+	client    TelemetryClient
+	transport http.RoundTripper
+}
+
+type internalRequestKey struct{}
+
+// Marks a request context as belonging to one of the SDK's own requests,
+// such as telemetry submissions and Live Metrics, which HttpTransport does
+// not track.
+func withInternalRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, internalRequestKey{}, true)
+}
+
+func isInternalRequest(ctx context.Context) bool {
+	internal, _ := ctx.Value(internalRequestKey{}).(bool)
+	return internal
+}
+
+// Creates an HttpTransport that sends requests through transport, which
+// may be nil to use http.DefaultTransport, and tracks them to the
+// specified client.
+func NewHttpTransport(client TelemetryClient, transport http.RoundTripper) *HttpTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &HttpTransport{
+		client:    client,
+		transport: transport,
+	}
+}
+
+// Sends the request with the wrapped transport and tracks it.
+func (t *HttpTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if isInternalRequest(request.Context()) {
+		// Don't track the SDK's own requests.
+		return t.transport.RoundTrip(request)
+	}
+
+	context := t.client.Context()
+	_, operation := t.client.StartDependencyOperation(request.Context(), request.Method+" "+request.URL.Path, "HTTP", request.URL.Host)
+	dependency := operation.Telemetry().(*RemoteDependencyTelemetry)
+
+	// Leave out any credentials in the URL.
+	data := *request.URL
+	data.User = nil
+	dependency.Data = data.String()
+
+	// RoundTrippers must not modify the original request.
+	outgoing := new(http.Request)
+	*outgoing = *request
+	outgoing.Header = make(http.Header, len(request.Header)+1)
+	for k, v := range request.Header {
+		outgoing.Header[k] = v
+	}
+
+	injectCorrelationHeaders(outgoing.Header, operation, context.Propagation)
+	setRequestContextAppId(outgoing.Header, context)
+
+	response, err := t.transport.RoundTrip(outgoing)
+	if err == nil {
+		dependency.ResultCode = strconv.Itoa(response.StatusCode)
+		dependency.Success = response.StatusCode < 400
+
+		if appId := requestContextAppId(response.Header); isOtherAppId(appId, context) {
+			dependency.Type = trackedComponentDependencyType
+			dependency.Target += " | " + appId
+		}
+	} else {
+		dependency.Success = false
+	}
+
+	operation.End()
+	return response, err
+}
@@ -0,0 +1,225 @@
+package appinsights
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func dependencyData(t *testing.T, envelope *contracts.Envelope) *contracts.RemoteDependencyData {
+	data, ok := envelope.Data.(*contracts.Data).BaseData.(*contracts.RemoteDependencyData)
+	if !ok {
+		t.Fatalf("Expected dependency telemetry, got %s", envelope.Name)
+	}
+
+	return data
+}
+
+func TestHttpTransportCorrelation(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	callee := httptest.NewServer(NewHttpHandler(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	})))
+	defer callee.Close()
+
+	httpClient := &http.Client{Transport: NewHttpTransport(client, nil)}
+	handler := NewHttpHandler(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outbound, _ := http.NewRequest("POST", callee.URL+"/callee?x=y", nil)
+		outbound.Header.Set("X-Custom", "1")
+		response, err := httpClient.Do(outbound.WithContext(r.Context()))
+		if err != nil {
+			t.Fatalf("Outbound request failed: %s", err.Error())
+		}
+
+		response.Body.Close()
+		if outbound.Header.Get(requestIdHeader) != "" {
+			t.Error("Transport modified the original request")
+		}
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/caller", nil))
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 3 {
+		t.Fatalf("Tracked %d items, want 3", len(items))
+	}
+
+	calleeReq, dep, callerReq := items[0], items[1], items[2]
+	callerData := requestData(t, callerReq)
+	depData := dependencyData(t, dep)
+	calleeData := requestData(t, calleeReq)
+
+	checkDataContract(t, "Name", depData.Name, "POST /callee")
+	checkDataContract(t, "Type", depData.Type, "HTTP")
+	checkDataContract(t, "Target", depData.Target, callee.Listener.Addr().String())
+	checkDataContract(t, "Data", depData.Data, callee.URL+"/callee?x=y")
+	checkDataContract(t, "ResultCode", depData.ResultCode, "503")
+	checkDataContract(t, "Success", depData.Success, false)
+
+	operationId := callerReq.Tags[contracts.OperationId]
+	checkDataContract(t, "dependency ai.operation.id", dep.Tags[contracts.OperationId], operationId)
+	checkDataContract(t, "dependency ai.operation.parentId", dep.Tags[contracts.OperationParentId], callerData.Id)
+	checkDataContract(t, "callee ai.operation.id", calleeReq.Tags[contracts.OperationId], operationId)
+	checkDataContract(t, "callee ai.operation.parentId", calleeReq.Tags[contracts.OperationParentId], depData.Id)
+	checkDataContract(t, "callee ResponseCode", calleeData.ResponseCode, "503")
+}
+
+func TestHttpTransportWithoutOperation(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(traceparentHeader)
+	}))
+	defer server.Close()
+
+	httpClient := &http.Client{Transport: NewHttpTransport(client, nil)}
+	response, err := httpClient.Get(server.URL + "/path")
+	if err != nil {
+		t.Fatalf("Request failed: %s", err.Error())
+	}
+
+	response.Body.Close()
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 1 {
+		t.Fatalf("Tracked %d items, want 1", len(items))
+	}
+
+	traceId, spanId, flags, ok := parseTraceparent(traceparent)
+	if !ok {
+		t.Fatalf("Malformed traceparent: %q", traceparent)
+	}
+
+	dep := items[0]
+	data := dependencyData(t, dep)
+	checkDataContract(t, "Id", data.Id, spanId)
+	checkDataContract(t, "ResultCode", data.ResultCode, "200")
+	checkDataContract(t, "Success", data.Success, true)
+	checkDataContract(t, "ai.operation.id", dep.Tags[contracts.OperationId], traceId)
+	if flags != traceFlagSampled {
+		t.Errorf("Unexpected trace flags: %02x", flags)
+	}
+
+	if _, ok := dep.Tags[contracts.OperationParentId]; ok {
+		t.Error("Root dependency should not have a parent")
+	}
+}
+
+func TestHttpTransportFailure(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	httpClient := &http.Client{Transport: NewHttpTransport(client, nil)}
+	if _, err := httpClient.Get(url); err == nil {
+		t.Fatal("Expected request to fail")
+	}
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 1 {
+		t.Fatalf("Tracked %d items, want 1", len(items))
+	}
+
+	data := dependencyData(t, items[0])
+	checkDataContract(t, "ResultCode", data.ResultCode, "")
+	checkDataContract(t, "Success", data.Success, false)
+}
+
+func TestHttpTransportOmitsUserInfo(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	httpClient := &http.Client{Transport: NewHttpTransport(client, nil)}
+	address := strings.Replace(server.URL, "://", "://user:secret@", 1) + "/path"
+	response, err := httpClient.Get(address)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err.Error())
+	}
+
+	response.Body.Close()
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 1 {
+		t.Fatalf("Tracked %d items, want 1", len(items))
+	}
+
+	data := dependencyData(t, items[0])
+	checkDataContract(t, "Data", data.Data, server.URL+"/path")
+}
+
+func TestHttpTransportIgnoresInternalRequests(t *testing.T) {
+	pinged := make(chan struct{}, 16)
+	submitted := make(chan struct{}, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == trackPath:
+			submitted <- struct{}{}
+		case strings.HasPrefix(r.URL.Path, quickPulsePath):
+			w.Header().Set(quickPulseSubscribed, "false")
+			pinged <- struct{}{}
+		}
+	}))
+	defer server.Close()
+
+	// The application's own client tracks its outgoing requests...
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+	httpClient := &http.Client{Transport: NewHttpTransport(client, nil)}
+
+	// ...including those of another client that shares the HTTP client.
+	config := NewTelemetryConfiguration(test_ikey)
+	config.EndpointUrl = server.URL + trackPath
+	config.LiveEndpointUrl = server.URL
+	config.LiveMetrics = true
+	config.Client = httpClient
+	other := NewTelemetryClientFromConfig(config)
+
+	waitFor := func(ch chan struct{}, what string) {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+	}
+
+	waitFor(pinged, "ping")
+	other.TrackTrace("~msg~", Information)
+	waitForClose(t, other.Channel().Close(time.Second))
+	waitFor(submitted, "submission")
+
+	response, err := httpClient.Get(server.URL + "/path")
+	if err != nil {
+		t.Fatalf("Request failed: %s", err.Error())
+	}
+
+	response.Body.Close()
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 1 {
+		t.Fatalf("Tracked %d items, want 1", len(items))
+	}
+
+	checkDataContract(t, "Data", dependencyData(t, items[0]).Data, server.URL+"/path")
+}
@@ -0,0 +1,28 @@
+package appinsights
+
+import "context"
+
+// Telemetry initializers are run on each telemetry item as it is tracked,
+// before it is wrapped in an envelope.  They can be used to stamp values
+// onto telemetry that are only known at the time it is tracked, such as the
+// current build version or a tenant ID carried by a context.Context.
+//
+// Tags and properties set by an initializer take precedence over the
+// values found on the client's TelemetryContext.
+type TelemetryInitializer interface {
+	// Initializes the specified telemetry item.  ctx is the context the
+	// item was tracked with, or context.Background() if none.
+	Initialize(ctx context.Context, item Telemetry)
+}
+
+// Adapter that allows an ordinary function to be used as a
+// TelemetryInitializer.
+type TelemetryInitializerFunc func(ctx context.Context, item Telemetry)
+
+// Calls the function with the specified context and telemetry item.
+func (f TelemetryInitializerFunc) Initialize(ctx context.Context, item Telemetry) {
+	f(ctx, item)
+}
+
+// Context passed to initializers for telemetry that was tracked without one.
+var backgroundContext = context.Background()
@@ -0,0 +1,91 @@
+package appinsights
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+type tenantKey struct{}
+
+func TestInitializers(t *testing.T) {
+	telemetryContext := NewTelemetryContext(test_ikey)
+	telemetryContext.CommonProperties["tenant"] = "common"
+	telemetryContext.Tags.Application().SetVer("1.0")
+
+	version := "2.0"
+	telemetryContext.Initializers = []TelemetryInitializer{
+		TelemetryInitializerFunc(func(ctx context.Context, item Telemetry) {
+			if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+				item.GetProperties()["tenant"] = tenant
+			}
+
+			item.GetProperties()["order"] += "a"
+		}),
+		TelemetryInitializerFunc(func(ctx context.Context, item Telemetry) {
+			// Evaluated at track time, not when the client was created.
+			item.ContextTags()[contracts.ApplicationVersion] = version
+			item.GetProperties()["order"] += "b"
+		}),
+	}
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, "contoso")
+	envelope := telemetryContext.envelopWithContext(ctx, NewTraceTelemetry("~msg~", Information))
+	data := envelope.Data.(*contracts.Data).BaseData.(*contracts.MessageData)
+
+	if data.Properties["tenant"] != "contoso" {
+		t.Errorf("Tenant property is %s, want contoso", data.Properties["tenant"])
+	}
+
+	if data.Properties["order"] != "ab" {
+		t.Errorf("Initializers ran out of order: %s", data.Properties["order"])
+	}
+
+	if ver := envelope.Tags[contracts.ApplicationVersion]; ver != "2.0" {
+		t.Errorf("Application version is %s, want 2.0", ver)
+	}
+
+	// Without a context.Context, the common property applies.
+	version = "3.0"
+	envelope = telemetryContext.envelop(NewTraceTelemetry("~msg~", Information))
+	data = envelope.Data.(*contracts.Data).BaseData.(*contracts.MessageData)
+
+	if data.Properties["tenant"] != "common" {
+		t.Errorf("Tenant property is %s, want common", data.Properties["tenant"])
+	}
+
+	if ver := envelope.Tags[contracts.ApplicationVersion]; ver != "3.0" {
+		t.Errorf("Application version is %s, want 3.0", ver)
+	}
+}
+
+func TestInitializersFromConfiguration(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	config := NewTelemetryConfiguration(test_ikey)
+	config.MaxBatchInterval = ten_seconds
+	config.TelemetryInitializers = []TelemetryInitializer{
+		TelemetryInitializerFunc(func(ctx context.Context, item Telemetry) {
+			item.GetProperties()["initialized"] = "yes"
+		}),
+	}
+
+	client, transmitter := newTestChannelServer(config)
+	defer transmitter.Close()
+
+	transmitter.prepResponse(200)
+	client.TrackEvent("~event~")
+	waitForClose(t, client.Channel().Close())
+
+	req := transmitter.waitForRequest(t)
+	if len(req.items) != 1 {
+		t.Fatal("Unexpected payload")
+	}
+
+	data := req.items[0].Data.(*contracts.Data).BaseData.(*contracts.EventData)
+	if data.Properties["initialized"] != "yes" {
+		t.Error("Initializer from configuration did not run")
+	}
+}
@@ -2,6 +2,7 @@ package appinsights
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"code.cloudfoundry.org/clock"
@@ -12,18 +13,36 @@ var (
 	submit_retries = []time.Duration{time.Duration(10 * time.Second), time.Duration(30 * time.Second), time.Duration(60 * time.Second)}
 )
 
-// A telemetry channel that stores events exclusively in memory.  Presently
-// the only telemetry channel implementation available.
+const (
+	// Queue capacity used by the dropping policies when QueueCapacity is
+	// not positive.
+	defaultQueueCapacity = 1024
+
+	// Time that QueueBlockWithTimeout waits for room in the queue when
+	// QueueTimeout is not positive.
+	defaultQueueTimeout = time.Duration(1) * time.Second
+)
+
+// A telemetry channel that stores events exclusively in memory.  See
+// PersistentChannel for a channel that also keeps pending telemetry on disk.
 type InMemoryChannel struct {
+	dropped         int64 // accessed atomically; keep 64-bit aligned
+	lastTransmit    int32 // accessed atomically; a transmitStatus
 	endpointAddress string
 	isDeveloperMode bool
 	collectChan     chan *contracts.Envelope
 	controlChan     chan *inMemoryChannelControl
+	queuePolicy     QueuePolicy
+	queueTimeout    time.Duration
 	batchSize       int
 	batchInterval   time.Duration
 	waitgroup       sync.WaitGroup
 	throttle        *throttleManager
 	transmitter     transmitter
+	storage         *diskStorage
+	closeHooksLock  sync.Mutex
+	closeHooks      []func()
+	closing         bool
 }
 
 type inMemoryChannelControl struct {
@@ -39,6 +58,10 @@ type inMemoryChannelControl struct {
 	// If retrying, what is the max time to wait before finishing up?
 	timeout time.Duration
 
+	// If flushing, submit immediately even if the channel is throttled,
+	// and do not retry.  Used when the program is about to crash.
+	immediate bool
+
 	// If specified, a message will be sent on this channel when all pending telemetry items have been submitted
 	callback chan struct{}
 }
@@ -46,14 +69,42 @@ type inMemoryChannelControl struct {
 // Creates an InMemoryChannel instance and starts a background submission
 // goroutine.
 func NewInMemoryChannel(config *TelemetryConfiguration) *InMemoryChannel {
+	return newInMemoryChannel(config, newTransmitter(config.EndpointUrl, config.Client), nil)
+}
+
+// Creates an InMemoryChannel that submits through the specified
+// transmitter.  If storage is non-nil, each batch is written to it before
+// transmission and removed once it has been accepted.
+func newInMemoryChannel(config *TelemetryConfiguration, transmitter transmitter, storage *diskStorage) *InMemoryChannel {
+	capacity := config.QueueCapacity
+	if capacity <= 0 && (config.QueuePolicy == QueueDropNewest || config.QueuePolicy == QueueDropOldest) {
+		// Without a buffer, every item sent while the accept loop is busy
+		// would be dropped.
+		capacity = defaultQueueCapacity
+	}
+
+	if capacity < 0 {
+		capacity = 0
+	}
+
+	timeout := config.QueueTimeout
+	if timeout <= 0 {
+		// Otherwise every item sent while the accept loop is busy would
+		// be dropped without waiting.
+		timeout = defaultQueueTimeout
+	}
+
 	channel := &InMemoryChannel{
 		endpointAddress: config.EndpointUrl,
-		collectChan:     make(chan *contracts.Envelope),
+		collectChan:     make(chan *contracts.Envelope, capacity),
 		controlChan:     make(chan *inMemoryChannelControl),
+		queuePolicy:     config.QueuePolicy,
+		queueTimeout:    timeout,
 		batchSize:       config.MaxBatchSize,
 		batchInterval:   config.MaxBatchInterval,
 		throttle:        newThrottleManager(),
-		transmitter:     newTransmitter(config.EndpointUrl, config.Client),
+		transmitter:     transmitter,
+		storage:         storage,
 	}
 
 	go channel.acceptLoop()
@@ -66,13 +117,72 @@ func (channel *InMemoryChannel) EndpointAddress() string {
 	return channel.endpointAddress
 }
 
-// Queues a single telemetry item
+// Queues a single telemetry item.  If the queue is full, the item may be
+// dropped according to the configured QueuePolicy.
 func (channel *InMemoryChannel) Send(item *contracts.Envelope) {
-	if item != nil && channel.collectChan != nil {
+	if item == nil || channel.collectChan == nil {
+		return
+	}
+
+	switch channel.queuePolicy {
+	case QueueDropNewest:
+		select {
+		case channel.collectChan <- item:
+		default:
+			channel.drop(1)
+		}
+
+	case QueueDropOldest:
+		select {
+		case channel.collectChan <- item:
+			return
+		default:
+		}
+
+		// Make room by discarding the item at the front of the queue.
+		select {
+		case <-channel.collectChan:
+			channel.drop(1)
+		default:
+		}
+
+		select {
+		case channel.collectChan <- item:
+		default:
+			channel.drop(1)
+		}
+
+	case QueueBlockWithTimeout:
+		select {
+		case channel.collectChan <- item:
+			return
+		default:
+		}
+
+		timer := currentClock.NewTimer(channel.queueTimeout)
+		defer timer.Stop()
+
+		select {
+		case channel.collectChan <- item:
+		case <-timer.C():
+			channel.drop(1)
+		}
+
+	default:
 		channel.collectChan <- item
 	}
 }
 
+// Returns the number of telemetry items that have been discarded because
+// the queue was full or the channel was throttled.
+func (channel *InMemoryChannel) DroppedItems() int64 {
+	return atomic.LoadInt64(&channel.dropped)
+}
+
+func (channel *InMemoryChannel) drop(count int) {
+	atomic.AddInt64(&channel.dropped, int64(count))
+}
+
 // Forces the current queue to be sent
 func (channel *InMemoryChannel) Flush() {
 	if channel.controlChan != nil {
@@ -82,10 +192,31 @@ func (channel *InMemoryChannel) Flush() {
 	}
 }
 
+// Forces the current queue to be sent.  Returns a channel that is closed
+// once it, and any earlier submissions, have completed.  If immediate is
+// set, the queue is submitted once without retries, even if the channel is
+// throttled, and the channel is closed without waiting for earlier
+// submissions.
+func (channel *InMemoryChannel) flushCallback(immediate bool) <-chan struct{} {
+	if channel.controlChan == nil {
+		return nil
+	}
+
+	callback := make(chan struct{})
+	channel.controlChan <- &inMemoryChannelControl{
+		flush:     true,
+		immediate: immediate,
+		callback:  callback,
+	}
+
+	return callback
+}
+
 // Tears down the submission goroutines, closes internal channels.  Any
 // telemetry waiting to be sent is discarded.  Further calls to Send() have
 // undefined behavior.  This is a more abrupt version of Close().
 func (channel *InMemoryChannel) Stop() {
+	channel.runCloseHooks()
 	if channel.controlChan != nil {
 		channel.controlChan <- &inMemoryChannelControl{
 			stop: true,
@@ -116,6 +247,7 @@ func (channel *InMemoryChannel) IsThrottled() bool {
 // exiting, you should select on the result channel and your own timer to
 // avoid long delays.
 func (channel *InMemoryChannel) Close(timeout ...time.Duration) <-chan struct{} {
+	channel.runCloseHooks()
 	if channel.controlChan != nil {
 		callback := make(chan struct{})
 
@@ -139,6 +271,49 @@ func (channel *InMemoryChannel) Close(timeout ...time.Duration) <-chan struct{}
 	}
 }
 
+// Outcome of the most recent attempt to transmit telemetry.
+const (
+	transmitNone int32 = iota
+	transmitSucceeded
+	transmitFailed
+)
+
+// Gets whether the most recent attempt to transmit telemetry succeeded.
+// ok is false if there has been no attempt yet.
+func (channel *InMemoryChannel) lastTransmitSucceeded() (succeeded, ok bool) {
+	status := atomic.LoadInt32(&channel.lastTransmit)
+	return status == transmitSucceeded, status != transmitNone
+}
+
+// Registers a function to be called when the channel is closed or
+// stopped, before it stops accepting telemetry.  Used to stop goroutines
+// that track telemetry in the background.  If the channel is already
+// closing, f is called immediately.
+func (channel *InMemoryChannel) onClose(f func()) {
+	channel.closeHooksLock.Lock()
+	if !channel.closing {
+		channel.closeHooks = append(channel.closeHooks, f)
+		f = nil
+	}
+	channel.closeHooksLock.Unlock()
+
+	if f != nil {
+		f()
+	}
+}
+
+func (channel *InMemoryChannel) runCloseHooks() {
+	channel.closeHooksLock.Lock()
+	hooks := channel.closeHooks
+	channel.closeHooks = nil
+	channel.closing = true
+	channel.closeHooksLock.Unlock()
+
+	for _, f := range hooks {
+		f()
+	}
+}
+
 func (channel *InMemoryChannel) acceptLoop() {
 	channelState := newInMemoryChannelState(channel)
 
@@ -156,6 +331,8 @@ type inMemoryChannelState struct {
 	buffer       telemetryBufferItems
 	retry        bool
 	retryTimeout time.Duration
+	immediate    bool
+	flushing     bool
 	callback     chan struct{}
 	timer        clock.Timer
 }
@@ -194,13 +371,27 @@ func (state *inMemoryChannelState) start() bool {
 		state.buffer = append(state.buffer, event)
 
 	case ctl := <-state.channel.controlChan:
-		// The buffer is empty, so there would be no point in flushing
-		state.channel.signalWhenDone(ctl.callback)
+		// Items sent before this control message may still be queued.
+		state.drain()
 
-		if ctl.stop {
-			state.stopping = true
-			return false
+		if len(state.buffer) == 0 || !ctl.flush {
+			// The buffer is empty, so there would be no point in flushing
+			state.channel.signalWhenDone(ctl.callback)
+
+			if ctl.stop {
+				state.stopping = true
+				return false
+			}
+
+			return true
 		}
+
+		state.stopping = ctl.stop
+		state.retry = (!ctl.stop || ctl.retry) && !ctl.immediate
+		state.retryTimeout = ctl.timeout
+		state.immediate = ctl.immediate
+		state.callback = ctl.callback
+		return state.send()
 	}
 
 	if len(state.buffer) == 0 {
@@ -215,6 +406,8 @@ func (state *inMemoryChannelState) waitToSend() bool {
 	// Things that are used by the sender if we receive a control message
 	state.retryTimeout = 0
 	state.retry = true
+	state.immediate = false
+	state.flushing = false
 	state.callback = nil
 
 	// Delay until timeout passes or buffer fills up
@@ -239,6 +432,12 @@ func (state *inMemoryChannelState) waitToSend() bool {
 			state.buffer = append(state.buffer, event)
 
 		case ctl := <-state.channel.controlChan:
+			if ctl.flush {
+				// Items sent before this control message may
+				// still be queued.
+				state.drain()
+			}
+
 			if ctl.stop {
 				state.stopping = true
 				state.retry = ctl.retry
@@ -254,6 +453,11 @@ func (state *inMemoryChannelState) waitToSend() bool {
 					<-state.timer.C()
 				}
 
+				if ctl.immediate {
+					state.retry = false
+					state.immediate = true
+				}
+
 				state.retryTimeout = ctl.timeout
 				state.callback = ctl.callback
 				return state.send()
@@ -269,27 +473,65 @@ func (state *inMemoryChannelState) waitToSend() bool {
 // Part of channel accept loop: Check and wait on throttle, submit pending telemetry
 func (state *inMemoryChannelState) send() bool {
 	// Hold up transmission if we're being throttled
-	if !state.stopping && state.channel.throttle.IsThrottled() {
+	if !state.stopping && !state.immediate && state.channel.throttle.IsThrottled() {
 		if !state.waitThrottle() {
 			// Stopped
 			return false
 		}
 	}
 
-	// Send
+	// Send.  A flush also submits whatever is still queued, in batches
+	// of at most batchSize.
+	var batches []telemetryBufferItems
 	if len(state.buffer) > 0 {
-		state.channel.waitgroup.Add(1)
+		batches = append(batches, state.buffer)
+	}
+
+	for state.flushing && len(state.channel.collectChan) > 0 {
+		state.buffer = make(telemetryBufferItems, 0, state.channel.batchSize)
+		state.drain()
+		if len(state.buffer) == 0 {
+			break
+		}
+
+		batches = append(batches, state.buffer)
+	}
+
+	if len(batches) > 0 {
+		state.channel.waitgroup.Add(len(batches))
 
 		// If we have a callback, wait on the waitgroup now that it's
-		// incremented.
-		state.channel.signalWhenDone(state.callback)
+		// incremented.  Immediate submissions don't wait for earlier
+		// ones, which may be held up by throttling.
+		var immediate *sync.WaitGroup
+		if state.immediate {
+			immediate = &sync.WaitGroup{}
+			immediate.Add(len(batches))
+			if state.callback != nil {
+				go func(callback chan struct{}) {
+					immediate.Wait()
+					close(callback)
+				}(state.callback)
+			}
+		} else {
+			state.channel.signalWhenDone(state.callback)
+		}
 
-		go func(buffer telemetryBufferItems, retry bool, retryTimeout time.Duration) {
-			defer state.channel.waitgroup.Done()
-			state.channel.transmitRetry(buffer, retry, retryTimeout)
-		}(state.buffer, state.retry, state.retryTimeout)
+		for _, batch := range batches {
+			go func(buffer telemetryBufferItems, retry bool, retryTimeout time.Duration) {
+				defer state.channel.waitgroup.Done()
+				state.channel.transmitRetry(buffer, retry, retryTimeout)
+				if immediate != nil {
+					immediate.Done()
+				}
+			}(batch, state.retry, state.retryTimeout)
+		}
 	} else if state.callback != nil {
-		state.channel.signalWhenDone(state.callback)
+		if state.immediate {
+			close(state.callback)
+		} else {
+			state.channel.signalWhenDone(state.callback)
+		}
 	}
 
 	return true
@@ -305,7 +547,9 @@ func (state *inMemoryChannelState) waitThrottle() bool {
 	throttleDone := state.channel.throttle.NotifyWhenReady()
 	dropped := 0
 
-	defer diagnosticsWriter.Printf("Channel dropped %d events while throttled", dropped)
+	defer func() {
+		diagnosticsWriter.Printf("Channel dropped %d events while throttled", dropped)
+	}()
 
 	for {
 		select {
@@ -323,6 +567,7 @@ func (state *inMemoryChannelState) waitThrottle() bool {
 				}
 
 				dropped++
+				state.channel.drop(1)
 			}
 
 		case ctl := <-state.channel.controlChan:
@@ -334,10 +579,22 @@ func (state *inMemoryChannelState) waitThrottle() bool {
 					return false
 				} else {
 					// Make an exception when stopping
+					state.drain()
 					return true
 				}
 			}
 
+			if ctl.flush && ctl.immediate {
+				// The program is about to crash, so make an
+				// exception here too.
+				state.channel.signalWhenDone(state.callback)
+				state.retry = false
+				state.immediate = true
+				state.callback = ctl.callback
+				state.drain()
+				return true
+			}
+
 			// Cannot flush
 			// TODO: Figure out what to do about callback?
 			if ctl.flush {
@@ -347,6 +604,25 @@ func (state *inMemoryChannelState) waitThrottle() bool {
 	}
 }
 
+// Part of channel accept loop: Move items waiting in the queue into the
+// buffer, until it holds a full batch, and mark the buffer for flushing.
+// send() submits the rest of the queue in further batches.
+func (state *inMemoryChannelState) drain() {
+	state.flushing = true
+	for n := len(state.channel.collectChan); n > 0; n-- {
+		if state.channel.batchSize > 0 && len(state.buffer) >= state.channel.batchSize {
+			return
+		}
+
+		select {
+		case event := <-state.channel.collectChan:
+			state.buffer = append(state.buffer, event)
+		default:
+			return
+		}
+	}
+}
+
 // Part of channel accept loop: Clean up and close telemetry channel
 func (state *inMemoryChannelState) stop() {
 	close(state.channel.collectChan)
@@ -364,17 +640,38 @@ func (state *inMemoryChannelState) stop() {
 
 func (channel *InMemoryChannel) transmitRetry(items telemetryBufferItems, retry bool, retryTimeout time.Duration) {
 	payload := items.serialize()
+
+	if channel.storage == nil {
+		channel.transmitPayload(payload, items, retry, retryTimeout)
+		return
+	}
+
+	// Keep the batch on disk until the data collector has accepted it.
+	name := channel.storage.save(payload)
+	remainingPayload, remainingItems := channel.transmitPayload(payload, items, retry, retryTimeout)
+	channel.storage.settle(name, len(items), remainingPayload, remainingItems)
+}
+
+// Submits the payload, retrying on failure according to submit_retries.
+// Returns the payload and items that were not accepted by the data
+// collector, but could be submitted again later.
+func (channel *InMemoryChannel) transmitPayload(payload []byte, items telemetryBufferItems, retry bool, retryTimeout time.Duration) ([]byte, telemetryBufferItems) {
 	retryTimeRemaining := retryTimeout
 
 	for _, wait := range submit_retries {
-		result, err := channel.transmitter.Transmit(payload, items)
+		result, err := channel.transmit(payload, items)
 		if err == nil && result != nil && result.IsSuccess() {
-			return
+			return nil, nil
 		}
 
 		if !retry {
 			diagnosticsWriter.Write("Refusing to retry telemetry submission (retry==false)")
-			return
+			if result != nil {
+				// Later submissions must still honor Retry-After.
+				channel.applyThrottle(result)
+			}
+
+			return channel.retryableItems(result, payload, items)
 		}
 
 		// Check for success, determine if we need to retry anything
@@ -383,22 +680,15 @@ func (channel *InMemoryChannel) transmitRetry(items telemetryBufferItems, retry
 				// Filter down to failed items
 				payload, items = result.GetRetryItems(payload, items)
 				if len(payload) == 0 || len(items) == 0 {
-					return
+					return nil, nil
 				}
 			} else {
 				diagnosticsWriter.Write("Cannot retry telemetry submission")
-				return
+				return nil, nil
 			}
 
 			// Check for throttling
-			if result.IsThrottled() {
-				if result.retryAfter != nil {
-					diagnosticsWriter.Printf("Channel is throttled until %s", *result.retryAfter)
-					channel.throttle.RetryAfter(*result.retryAfter)
-				} else {
-					// TODO: Pick a time
-				}
-			}
+			channel.applyThrottle(result)
 		}
 
 		if retryTimeout > 0 {
@@ -427,16 +717,57 @@ func (channel *InMemoryChannel) transmitRetry(items telemetryBufferItems, retry
 			close(ch)
 
 			if !result {
-				return
+				return payload, items
 			}
 		}
 	}
 
 	// One final try
-	_, err := channel.transmitter.Transmit(payload, items)
+	result, err := channel.transmit(payload, items)
 	if err != nil {
 		diagnosticsWriter.Write("Gave up transmitting payload; exhausted retries")
 	}
+
+	if err == nil && result != nil && result.IsSuccess() {
+		return nil, nil
+	}
+
+	return channel.retryableItems(result, payload, items)
+}
+
+// Throttles the channel if the data collector asked us to back off.
+func (channel *InMemoryChannel) applyThrottle(result *transmissionResult) {
+	if result.IsThrottled() {
+		if result.retryAfter != nil {
+			diagnosticsWriter.Printf("Channel is throttled until %s", *result.retryAfter)
+			channel.throttle.RetryAfter(*result.retryAfter)
+		} else {
+			// TODO: Pick a time
+		}
+	}
+}
+
+// Submits the payload once, and records whether it succeeded.
+func (channel *InMemoryChannel) transmit(payload []byte, items telemetryBufferItems) (*transmissionResult, error) {
+	result, err := channel.transmitter.Transmit(payload, items)
+	if err == nil && result != nil && result.IsSuccess() {
+		atomic.StoreInt32(&channel.lastTransmit, transmitSucceeded)
+	} else {
+		atomic.StoreInt32(&channel.lastTransmit, transmitFailed)
+	}
+
+	return result, err
+}
+
+// Determines which items from a failed submission could still be accepted
+// if they were submitted again.
+func (channel *InMemoryChannel) retryableItems(result *transmissionResult, payload []byte, items telemetryBufferItems) ([]byte, telemetryBufferItems) {
+	if result == nil {
+		// No response at all; the whole batch is still pending.
+		return payload, items
+	}
+
+	return result.GetRetryItems(payload, items)
 }
 
 func (channel *InMemoryChannel) signalWhenDone(callback chan struct{}) {
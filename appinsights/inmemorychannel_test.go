@@ -5,6 +5,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
 )
 
 const ten_seconds = time.Duration(10) * time.Second
@@ -385,6 +387,35 @@ func TestRetryOnFailure(t *testing.T) {
 	assertTimeApprox(t, req2.timestamp, tm.Add(ten_seconds).Add(submit_retries[0]))
 }
 
+func TestFinalRetrySucceeds(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	// Fail every scheduled retry, and succeed on the last try.
+	for range submit_retries {
+		transmitter.prepResponse(500)
+	}
+
+	transmitter.prepResponse(200)
+
+	client.TrackTrace("~msg~", Information)
+	slowTick(10)
+	transmitter.waitForRequest(t)
+	for range submit_retries {
+		slowTick(60)
+		transmitter.waitForRequest(t)
+	}
+
+	waitForClose(t, client.Channel().Close())
+
+	channel := client.Channel().(*InMemoryChannel)
+	if succeeded, ok := channel.lastTransmitSucceeded(); !ok || !succeeded {
+		t.Error("Final successful try was not recorded")
+	}
+}
+
 func TestPartialRetry(t *testing.T) {
 	mockClock()
 	defer resetClock()
@@ -626,3 +657,221 @@ func TestThrottleStacking(t *testing.T) {
 
 	transmitter.assertNoRequest(t)
 }
+
+func newTestQueue(capacity int, policy QueuePolicy) *InMemoryChannel {
+	// No accept loop, so nothing drains the queue.
+	return &InMemoryChannel{
+		collectChan:  make(chan *contracts.Envelope, capacity),
+		queuePolicy:  policy,
+		queueTimeout: time.Second,
+	}
+}
+
+func queuedNames(channel *InMemoryChannel) []string {
+	var result []string
+	for len(channel.collectChan) > 0 {
+		result = append(result, (<-channel.collectChan).Name)
+	}
+
+	return result
+}
+
+func TestQueueDropNewest(t *testing.T) {
+	channel := newTestQueue(2, QueueDropNewest)
+	for _, name := range []string{"a", "b", "c"} {
+		channel.Send(&contracts.Envelope{Name: name})
+	}
+
+	if names := queuedNames(channel); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("Unexpected queue contents: %q", names)
+	}
+
+	if dropped := channel.DroppedItems(); dropped != 1 {
+		t.Errorf("DroppedItems is %d, want 1", dropped)
+	}
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	channel := newTestQueue(2, QueueDropOldest)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		channel.Send(&contracts.Envelope{Name: name})
+	}
+
+	if names := queuedNames(channel); len(names) != 2 || names[0] != "c" || names[1] != "d" {
+		t.Errorf("Unexpected queue contents: %q", names)
+	}
+
+	if dropped := channel.DroppedItems(); dropped != 2 {
+		t.Errorf("DroppedItems is %d, want 2", dropped)
+	}
+}
+
+func TestQueueBlockWithTimeout(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	channel := newTestQueue(1, QueueBlockWithTimeout)
+	channel.Send(&contracts.Envelope{Name: "a"})
+
+	done := make(chan struct{})
+	go func() {
+		channel.Send(&contracts.Envelope{Name: "b"})
+		close(done)
+	}()
+
+	fakeClock.WaitForWatcherAndIncrement(time.Second)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Send did not time out")
+	}
+
+	if names := queuedNames(channel); len(names) != 1 || names[0] != "a" {
+		t.Errorf("Unexpected queue contents: %q", names)
+	}
+
+	if dropped := channel.DroppedItems(); dropped != 1 {
+		t.Errorf("DroppedItems is %d, want 1", dropped)
+	}
+}
+
+func TestQueueDropPoliciesDefaultCapacity(t *testing.T) {
+	for _, policy := range []QueuePolicy{QueueDropNewest, QueueDropOldest} {
+		config := NewTelemetryConfiguration("")
+		config.QueuePolicy = policy
+		channel := newInMemoryChannel(config, &testTransmitter{}, nil)
+		if capacity := cap(channel.collectChan); capacity != defaultQueueCapacity {
+			t.Errorf("Queue capacity for policy %d is %d, want %d", policy, capacity, defaultQueueCapacity)
+		}
+
+		channel.Stop()
+	}
+
+	config := NewTelemetryConfiguration("")
+	channel := newInMemoryChannel(config, &testTransmitter{}, nil)
+	if capacity := cap(channel.collectChan); capacity != 0 {
+		t.Errorf("Queue capacity for QueueBlock is %d, want 0", capacity)
+	}
+
+	channel.Stop()
+}
+
+func TestQueueBlockWithTimeoutDefault(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	config := NewTelemetryConfiguration("")
+	config.QueuePolicy = QueueBlockWithTimeout
+	configured := newInMemoryChannel(config, &testTransmitter{}, nil)
+	configured.Stop()
+	if configured.queueTimeout != defaultQueueTimeout {
+		t.Fatalf("Queue timeout is %s, want %s", configured.queueTimeout, defaultQueueTimeout)
+	}
+
+	channel := newTestQueue(1, QueueBlockWithTimeout)
+	channel.queueTimeout = configured.queueTimeout
+	channel.Send(&contracts.Envelope{Name: "a"})
+
+	done := make(chan struct{})
+	go func() {
+		channel.Send(&contracts.Envelope{Name: "b"})
+		close(done)
+	}()
+
+	// A zero QueueTimeout does not drop the item straight away.
+	fakeClock.WaitForWatcherAndIncrement(defaultQueueTimeout / 2)
+	select {
+	case <-done:
+		t.Fatal("Send gave up before the default timeout")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	fakeClock.Increment(defaultQueueTimeout / 2)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Send did not time out")
+	}
+
+	if dropped := channel.DroppedItems(); dropped != 1 {
+		t.Errorf("DroppedItems is %d, want 1", dropped)
+	}
+}
+
+func TestQueuedItemsAreFlushed(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	config := NewTelemetryConfiguration("")
+	config.MaxBatchInterval = ten_seconds
+	config.QueueCapacity = 64
+	client, transmitter := newTestChannelServer(config)
+	defer transmitter.Close()
+
+	transmitter.prepResponse(200)
+
+	for i := 0; i < 32; i++ {
+		client.TrackTrace(fmt.Sprintf("~msg-%d~", i), Information)
+	}
+
+	waitForClose(t, client.Channel().Close())
+
+	req := transmitter.waitForRequest(t)
+	if len(req.items) != 32 {
+		t.Errorf("Flushed %d items, want 32", len(req.items))
+	}
+}
+
+func TestQueuedItemsRespectBatchSize(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	config := NewTelemetryConfiguration("")
+	config.MaxBatchInterval = ten_seconds
+	config.MaxBatchSize = 4
+	config.QueueCapacity = 64
+	client, transmitter := newTestChannelServer(config)
+	defer transmitter.Close()
+
+	for i := 0; i < 8; i++ {
+		transmitter.prepResponse(200)
+	}
+
+	for i := 0; i < 32; i++ {
+		client.TrackTrace(fmt.Sprintf("~msg-%d~", i), Information)
+	}
+
+	waitForClose(t, client.Channel().Close())
+
+	total := 0
+	for total < 32 {
+		req := transmitter.waitForRequest(t)
+		if len(req.items) > 4 {
+			t.Errorf("Request carried %d items, want at most 4", len(req.items))
+		}
+
+		total += len(req.items)
+	}
+
+	if total != 32 {
+		t.Errorf("Flushed %d items, want 32", total)
+	}
+
+	transmitter.assertNoRequest(t)
+}
+
+func TestDroppedItemsFromClient(t *testing.T) {
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+	defer client.Channel().Stop()
+
+	counter, ok := client.Channel().(DroppedItemsChannel)
+	if !ok {
+		t.Fatal("Channel does not count dropped items")
+	}
+
+	if dropped := counter.DroppedItems(); dropped != 0 {
+		t.Errorf("DroppedItems is %d, want 0", dropped)
+	}
+}
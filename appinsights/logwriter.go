@@ -0,0 +1,165 @@
+package appinsights
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// An io.Writer that tracks what is written to it as TraceTelemetry, for use
+// with log.SetOutput or log.New.  Each call to Write is tracked as a single
+// message, even if it spans several lines, since log.Logger writes each
+// message with one call.  Text that does not end with a newline is held
+// until the rest of its line is written, or Flush is called.
+type LogWriter struct {
+	// Severity of messages that do not start with one of the
+	// SeverityPrefixes.
+	Severity contracts.SeverityLevel
+
+	// Maps prefixes, such as "ERROR:", to the severity of messages that
+	// start with them.  The date, time and file name that log.Logger may
+	// write before the message are skipped.  If several prefixes match,
+	// the longest wins.  Nil disables severity detection.
+	SeverityPrefixes map[string]contracts.SeverityLevel
+
+	// If not nil, everything written is also written to Tee, such as
+	// the log's original output.
+	Tee io.Writer
+
+	client  TelemetryClient
+	lock    sync.Mutex
+	pending []byte
+}
+
+// Creates a LogWriter that tracks messages to the specified client with the
+// specified severity.
+func NewLogWriter(client TelemetryClient, severity contracts.SeverityLevel) *LogWriter {
+	return &LogWriter{
+		Severity: severity,
+		client:   client,
+	}
+}
+
+// Gets a new map of commonly used severity prefixes, suitable for
+// LogWriter.SeverityPrefixes.
+func DefaultLogSeverityPrefixes() map[string]contracts.SeverityLevel {
+	return map[string]contracts.SeverityLevel{
+		"DEBUG:":    Verbose,
+		"TRACE:":    Verbose,
+		"INFO:":     Information,
+		"WARN:":     Warning,
+		"WARNING:":  Warning,
+		"ERROR:":    Error,
+		"CRITICAL:": Critical,
+		"FATAL:":    Critical,
+		"PANIC:":    Critical,
+	}
+}
+
+// Tracks the complete lines in p as a single message, and writes p to Tee
+// if it is set.
+func (writer *LogWriter) Write(p []byte) (int, error) {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+
+	if end := bytes.LastIndexByte(p, '\n'); end >= 0 {
+		message := string(writer.pending) + string(p[:end])
+		writer.pending = append(writer.pending[:0], p[end+1:]...)
+		writer.track(message)
+	} else {
+		writer.pending = append(writer.pending, p...)
+	}
+
+	if writer.Tee != nil {
+		return writer.Tee.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// Tracks any text that was written without a trailing newline.
+func (writer *LogWriter) Flush() {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+
+	if len(writer.pending) > 0 {
+		message := string(writer.pending)
+		writer.pending = writer.pending[:0]
+		writer.track(message)
+	}
+}
+
+func (writer *LogWriter) track(message string) {
+	message = strings.TrimRight(message, "\r\n")
+	if strings.TrimSpace(message) == "" {
+		return
+	}
+
+	writer.client.Track(NewTraceTelemetry(message, writer.severityOf(message)))
+}
+
+// Determines the severity of a message from its prefix.
+func (writer *LogWriter) severityOf(message string) contracts.SeverityLevel {
+	severity := writer.Severity
+	if len(writer.SeverityPrefixes) == 0 {
+		return severity
+	}
+
+	message = skipLogHeader(message)
+	longest := 0
+	for prefix, prefixSeverity := range writer.SeverityPrefixes {
+		if len(prefix) > longest && strings.HasPrefix(message, prefix) {
+			severity = prefixSeverity
+			longest = len(prefix)
+		}
+	}
+
+	return severity
+}
+
+// Skips the date, time and file name that log.Logger writes before the
+// message, depending on its flags.
+func skipLogHeader(message string) string {
+	for {
+		message = strings.TrimLeft(message, " ")
+		space := strings.IndexByte(message, ' ')
+		if space < 0 {
+			return message
+		}
+
+		field := message[:space]
+		if !isLogDateOrTime(field) && !isLogFileName(field) {
+			return message
+		}
+
+		message = message[space+1:]
+	}
+}
+
+// Matches fields such as "2009/01/23" and "01:23:23.123123".
+func isLogDateOrTime(field string) bool {
+	if field == "" {
+		return false
+	}
+
+	for _, c := range field {
+		if (c < '0' || c > '9') && c != '/' && c != ':' && c != '.' {
+			return false
+		}
+	}
+
+	return strings.ContainsAny(field, "/:")
+}
+
+// Matches fields such as "file.go:23:".
+func isLogFileName(field string) bool {
+	if !strings.HasSuffix(field, ":") {
+		return false
+	}
+
+	field = strings.TrimRight(field[:len(field)-1], "0123456789")
+	return strings.HasSuffix(field, ".go:")
+}
@@ -0,0 +1,106 @@
+package appinsights
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func messageData(t *testing.T, envelope *contracts.Envelope) *contracts.MessageData {
+	data, ok := envelope.Data.(*contracts.Data).BaseData.(*contracts.MessageData)
+	if !ok {
+		t.Fatalf("Expected trace telemetry, got %s", envelope.Name)
+	}
+
+	return data
+}
+
+func TestLogWriter(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	var tee bytes.Buffer
+	writer := NewLogWriter(client, Information)
+	writer.SeverityPrefixes = DefaultLogSeverityPrefixes()
+	writer.Tee = &tee
+
+	logger := log.New(writer, "", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
+	logger.Printf("~plain~")
+	logger.Printf("WARNING: ~first~\n\tcontinued")
+	logger.Printf("ERROR:~error~")
+
+	// Partial lines are held until they are completed.
+	writer.Write([]byte("DEBUG: ~partial"))
+	writer.Write([]byte(" line~\n"))
+	writer.Write([]byte("~unterminated~"))
+	writer.Flush()
+	writer.Write([]byte("\n\n"))
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 5 {
+		t.Fatalf("Tracked %d items, want 5", len(items))
+	}
+
+	expected := []struct {
+		suffix   string
+		severity contracts.SeverityLevel
+	}{
+		{"~plain~", Information},
+		{"WARNING: ~first~\n\tcontinued", Warning},
+		{"ERROR:~error~", Error},
+		{"DEBUG: ~partial line~", Verbose},
+		{"~unterminated~", Information},
+	}
+
+	for i, e := range expected {
+		data := messageData(t, items[i])
+		if len(data.Message) < len(e.suffix) || data.Message[len(data.Message)-len(e.suffix):] != e.suffix {
+			t.Errorf("Unexpected message %q, want suffix %q", data.Message, e.suffix)
+		}
+
+		checkDataContract(t, "SeverityLevel", data.SeverityLevel, e.severity)
+	}
+
+	if !bytes.Contains(tee.Bytes(), []byte("~plain~\n")) || !bytes.HasSuffix(tee.Bytes(), []byte("~unterminated~\n\n")) {
+		t.Errorf("Unexpected tee output: %q", tee.String())
+	}
+}
+
+func TestLogWriterWithoutDetection(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	logger := log.New(NewLogWriter(client, Warning), "", 0)
+	logger.Print("ERROR: ~msg~")
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 1 {
+		t.Fatalf("Tracked %d items, want 1", len(items))
+	}
+
+	data := messageData(t, items[0])
+	checkDataContract(t, "Message", data.Message, "ERROR: ~msg~")
+	checkDataContract(t, "SeverityLevel", data.SeverityLevel, Warning)
+}
+
+func TestSkipLogHeader(t *testing.T) {
+	tests := map[string]string{
+		"2009/01/23 01:23:23 msg":                    "msg",
+		"2009/01/23 01:23:23.123123 file.go:23: msg": "msg",
+		"/a/b/c/file.go:23: msg":                     "msg",
+		"prefix: msg":                                "prefix: msg",
+		"12 items":                                   "12 items",
+	}
+
+	for line, expected := range tests {
+		if msg := skipLogHeader(line); msg != expected {
+			t.Errorf("skipLogHeader(%q) = %q, want %q", line, msg, expected)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package appinsights
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Maximum relative error of the percentiles estimated by MetricHistogram.
+const histogramRelativeAccuracy = 0.01
+
+// Ratio between the bounds of consecutive histogram buckets.
+var histogramGamma = (1.0 + histogramRelativeAccuracy) / (1.0 - histogramRelativeAccuracy)
+
+// Aggregates metric values like AggregateMetricTelemetry.AddData, and also
+// keeps a histogram of them from which percentiles can be estimated.
+// Buckets grow exponentially, so that estimates are within 1% of a value
+// that was added, whatever the magnitude of the values.
+type MetricHistogram struct {
+	// Count, sum, min, max and standard deviation of the values added.
+	Aggregate *AggregateMetricTelemetry
+
+	positive map[int]int
+	negative map[int]int
+	zeros    int
+}
+
+// Creates a new, empty histogram for the metric with the specified name.
+func NewMetricHistogram(name string) *MetricHistogram {
+	return &MetricHistogram{
+		Aggregate: NewAggregateMetricTelemetry(name),
+		positive:  make(map[int]int),
+		negative:  make(map[int]int),
+	}
+}
+
+// Adds data points to the histogram and to its Aggregate.  Values that are
+// infinite or NaN cannot be placed in a bucket, and are discarded.
+func (histogram *MetricHistogram) AddData(values []float64) {
+	if finite := finiteValues(values); len(finite) < len(values) {
+		diagnosticsWriter.Printf("Metric %s discarded %d values that are infinite or NaN", histogram.Aggregate.Name, len(values)-len(finite))
+		values = finite
+	}
+
+	histogram.Aggregate.AddData(values)
+	if histogram.positive == nil {
+		histogram.positive = make(map[int]int)
+		histogram.negative = make(map[int]int)
+	}
+
+	for _, x := range values {
+		if x > 0.0 {
+			histogram.positive[histogramBucket(x)]++
+		} else if x < 0.0 {
+			histogram.negative[histogramBucket(-x)]++
+		} else {
+			histogram.zeros++
+		}
+	}
+}
+
+// Estimates the value below which the specified percentage of the data
+// points fall.  Percentile(0) and Percentile(100) are the exact minimum
+// and maximum.  Returns 0 if no data has been added.
+func (histogram *MetricHistogram) Percentile(percentile float64) float64 {
+	agg := histogram.Aggregate
+	if agg.Count == 0 {
+		return 0.0
+	}
+
+	if percentile <= 0.0 {
+		return agg.Min
+	}
+
+	if percentile >= 100.0 {
+		return agg.Max
+	}
+
+	// Nearest rank, counting from zero.
+	rank := int(percentile/100.0*float64(agg.Count-1) + 0.5)
+
+	// Walk the buckets from the lowest value to the highest.
+	seen := 0
+	for _, bucket := range sortedBuckets(histogram.negative, true) {
+		seen += histogram.negative[bucket]
+		if seen > rank {
+			return clampPercentile(-histogramValue(bucket), agg)
+		}
+	}
+
+	seen += histogram.zeros
+	if seen > rank {
+		return 0.0
+	}
+
+	for _, bucket := range sortedBuckets(histogram.positive, false) {
+		seen += histogram.positive[bucket]
+		if seen > rank {
+			return clampPercentile(histogramValue(bucket), agg)
+		}
+	}
+
+	return agg.Max
+}
+
+// Creates metric telemetry items containing the estimates of the specified
+// percentiles.  They are named after the Aggregate with a suffix, such as
+// "latency_p95" for the 95th percentile of "latency", and have copies of its
+// timestamp, tags and properties.
+func (histogram *MetricHistogram) PercentileTelemetry(percentiles ...float64) []*MetricTelemetry {
+	agg := histogram.Aggregate
+	result := make([]*MetricTelemetry, 0, len(percentiles))
+	for _, percentile := range percentiles {
+		name := agg.Name + "_p" + strconv.FormatFloat(percentile, 'f', -1, 64)
+		metric := NewMetricTelemetry(name, histogram.Percentile(percentile))
+		metric.Timestamp = agg.Timestamp
+		for k, v := range agg.Tags {
+			metric.Tags[k] = v
+		}
+
+		for k, v := range agg.Properties {
+			metric.Properties[k] = v
+		}
+
+		result = append(result, metric)
+	}
+
+	return result
+}
+
+// Gets the index of the bucket that contains a positive value.  Bucket i
+// holds values in (gamma^(i-1), gamma^i].
+func histogramBucket(x float64) int {
+	return int(math.Ceil(math.Log(x) / math.Log(histogramGamma)))
+}
+
+// Gets the value that represents a bucket, which is within the relative
+// accuracy of every value in it.
+func histogramValue(bucket int) float64 {
+	return 2.0 * math.Pow(histogramGamma, float64(bucket)) / (histogramGamma + 1.0)
+}
+
+// Gets the values that are neither infinite nor NaN.  Returns values itself
+// if they all are.
+func finiteValues(values []float64) []float64 {
+	for i, x := range values {
+		if !math.IsInf(x, 0) && !math.IsNaN(x) {
+			continue
+		}
+
+		result := append([]float64(nil), values[:i]...)
+		for _, x := range values[i+1:] {
+			if !math.IsInf(x, 0) && !math.IsNaN(x) {
+				result = append(result, x)
+			}
+		}
+
+		return result
+	}
+
+	return values
+}
+
+func sortedBuckets(buckets map[int]int, descending bool) []int {
+	keys := make([]int, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+
+	if descending {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+
+	return keys
+}
+
+func clampPercentile(x float64, agg *AggregateMetricTelemetry) float64 {
+	return math.Max(agg.Min, math.Min(agg.Max, x))
+}
@@ -0,0 +1,90 @@
+package appinsights
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func checkPercentile(t *testing.T, histogram *MetricHistogram, percentile, expected float64) {
+	actual := histogram.Percentile(percentile)
+	// Allow for the choice of rank as well as the bucket's accuracy.
+	if math.Abs(actual-expected) > math.Abs(expected)*2*histogramRelativeAccuracy {
+		t.Errorf("Percentile %g is %g, want %g", percentile, actual, expected)
+	}
+}
+
+func TestMetricHistogram(t *testing.T) {
+	histogram := NewMetricHistogram("latency")
+	if p := histogram.Percentile(50); p != 0.0 {
+		t.Errorf("Percentile of empty histogram is %g, want 0", p)
+	}
+
+	var values []float64
+	for i := 1; i <= 1000; i++ {
+		values = append(values, float64(i))
+	}
+
+	histogram.AddData(values)
+
+	checkDataContract(t, "Count", histogram.Aggregate.Count, 1000)
+	checkDataContract(t, "Value", histogram.Aggregate.Value, 500500.0)
+	checkDataContract(t, "StdDev", histogram.Aggregate.TelemetryData().(*contracts.MetricData).Metrics[0].StdDev, 288.6750)
+
+	checkPercentile(t, histogram, 0, 1.0)
+	checkPercentile(t, histogram, 50, 500.0)
+	checkPercentile(t, histogram, 95, 950.0)
+	checkPercentile(t, histogram, 99.9, 999.0)
+	checkPercentile(t, histogram, 100, 1000.0)
+}
+
+func TestMetricHistogramSigns(t *testing.T) {
+	histogram := NewMetricHistogram("delta")
+	histogram.AddData([]float64{1e9, -10.0, 0.0, 0.001, -1000.0, 5.0})
+
+	checkPercentile(t, histogram, 0, -1000.0)
+	checkPercentile(t, histogram, 20, -10.0)
+	checkPercentile(t, histogram, 40, 0.0)
+	checkPercentile(t, histogram, 60, 0.001)
+	checkPercentile(t, histogram, 80, 5.0)
+	checkPercentile(t, histogram, 100, 1e9)
+}
+
+func TestMetricHistogramNonFinite(t *testing.T) {
+	histogram := NewMetricHistogram("latency")
+	values := []float64{1.0, math.Inf(1), 2.0, math.NaN(), math.Inf(-1), 3.0}
+	histogram.AddData(values)
+
+	checkDataContract(t, "Count", histogram.Aggregate.Count, 3)
+	checkDataContract(t, "Value", histogram.Aggregate.Value, 6.0)
+	checkDataContract(t, "Max", histogram.Aggregate.Max, 3.0)
+	checkDataContract(t, "Min", histogram.Aggregate.Min, 1.0)
+	checkPercentile(t, histogram, 50, 2.0)
+	checkPercentile(t, histogram, 99, 3.0)
+	if !math.IsInf(values[1], 1) {
+		t.Error("AddData modified its argument")
+	}
+}
+
+func TestMetricHistogramPercentileTelemetry(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	histogram := NewMetricHistogram("latency")
+	histogram.Aggregate.Properties["method"] = "GET"
+	histogram.Aggregate.Timestamp = currentClock.Now().Add(-time.Minute)
+	histogram.AddData([]float64{1.0, 2.0, 3.0, 4.0})
+
+	metrics := histogram.PercentileTelemetry(50, 99.5)
+	if len(metrics) != 2 {
+		t.Fatalf("Got %d metrics, want 2", len(metrics))
+	}
+
+	checkDataContract(t, "Name", metrics[0].Name, "latency_p50")
+	checkDataContract(t, "Name", metrics[1].Name, "latency_p99.5")
+	checkDataContract(t, "Properties[method]", metrics[1].Properties["method"], "GET")
+	checkDataContract(t, "Timestamp", metrics[0].Timestamp, histogram.Aggregate.Timestamp)
+	checkPercentile(t, histogram, 99.5, 4.0)
+}
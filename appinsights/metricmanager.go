@@ -0,0 +1,314 @@
+package appinsights
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Property that tells the data collector how long the interval covered by
+// a pre-aggregated metric was, in milliseconds.
+const metricAggregationIntervalProperty = "_MS.AggregationIntervalMs"
+
+// Settings used to initialize a new MetricManager.
+type MetricSettings struct {
+	// How often aggregated values are submitted.
+	AggregationInterval time.Duration
+
+	// Maximum number of dimension combinations that each metric tracks at
+	// a time.  Values for further combinations are discarded until a
+	// combination goes unused for an entire interval.
+	MaxSeriesPerMetric int
+
+	// Percentiles, from 0 to 100, that are estimated for every metric and
+	// combination of dimension values, and submitted alongside the
+	// aggregates.  See MetricHistogram.PercentileTelemetry.
+	Percentiles []float64
+}
+
+// Creates a new MetricSettings object with default values.
+func NewMetricSettings() *MetricSettings {
+	return &MetricSettings{
+		AggregationInterval: time.Duration(60) * time.Second,
+		MaxSeriesPerMetric:  1000,
+	}
+}
+
+// Aggregates values tracked through its Metrics in memory, and periodically
+// submits one AggregateMetricTelemetry item for each metric and combination
+// of dimension values, instead of one item per value.
+type MetricManager struct {
+	client        TelemetryClient
+	settings      MetricSettings
+	lock          sync.Mutex
+	metrics       map[string]*Metric
+	intervalStart time.Time
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+// Creates a MetricManager that submits aggregated metrics to the specified
+// client, and starts a background goroutine that submits them every
+// AggregationInterval.  If settings is nil, the defaults from
+// NewMetricSettings are used; likewise for any field that is not positive.
+func NewMetricManager(client TelemetryClient, settings *MetricSettings) *MetricManager {
+	defaults := NewMetricSettings()
+	if settings == nil {
+		settings = defaults
+	}
+
+	validated := *settings
+	if validated.AggregationInterval <= 0 {
+		validated.AggregationInterval = defaults.AggregationInterval
+	}
+
+	if validated.MaxSeriesPerMetric <= 0 {
+		validated.MaxSeriesPerMetric = defaults.MaxSeriesPerMetric
+	}
+
+	manager := &MetricManager{
+		client:        client,
+		settings:      validated,
+		metrics:       make(map[string]*Metric),
+		intervalStart: currentClock.Now(),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	go manager.aggregateLoop()
+
+	return manager
+}
+
+// Gets the metric with the specified name and dimension names, creating it
+// if necessary.  Values tracked to it must specify one value for each
+// dimension, in the same order.
+func (manager *MetricManager) GetMetric(name string, dimensionNames ...string) *Metric {
+	key := name + "\x00" + strings.Join(dimensionNames, "\x00")
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	if metric, ok := manager.metrics[key]; ok {
+		return metric
+	}
+
+	metric := &Metric{
+		manager:        manager,
+		name:           name,
+		dimensionNames: append([]string(nil), dimensionNames...),
+		series:         make(map[string]*metricSeries),
+	}
+
+	manager.metrics[key] = metric
+	return metric
+}
+
+// Submits the values aggregated so far, and starts a new interval.
+func (manager *MetricManager) Flush() {
+	manager.lock.Lock()
+	now := currentClock.Now()
+	start := manager.intervalStart
+	manager.intervalStart = now
+
+	metrics := make([]*Metric, 0, len(manager.metrics))
+	for _, metric := range manager.metrics {
+		metrics = append(metrics, metric)
+	}
+	manager.lock.Unlock()
+
+	interval := strconv.FormatInt(int64(now.Sub(start)/time.Millisecond), 10)
+	for _, metric := range metrics {
+		for _, series := range metric.collect() {
+			series.Aggregate.Timestamp = start
+			percentiles := series.PercentileTelemetry(manager.settings.Percentiles...)
+
+			series.Aggregate.Properties[metricAggregationIntervalProperty] = interval
+			manager.client.Track(series.Aggregate)
+			for _, percentile := range percentiles {
+				manager.client.Track(percentile)
+			}
+		}
+	}
+}
+
+// Submits any remaining aggregated values and stops the background
+// goroutine.  Returns once they have been handed to the client, so the
+// client's channel should be closed afterwards.  Values tracked after Stop
+// are never submitted.
+func (manager *MetricManager) Stop() {
+	select {
+	case <-manager.stop:
+	default:
+		close(manager.stop)
+	}
+
+	<-manager.done
+}
+
+func (manager *MetricManager) aggregateLoop() {
+	defer close(manager.done)
+
+	timer := currentClock.NewTimer(manager.settings.AggregationInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-manager.stop:
+			manager.Flush()
+			return
+		case <-timer.C():
+			manager.Flush()
+			timer.Reset(manager.settings.AggregationInterval)
+		}
+	}
+}
+
+// A metric whose values are aggregated by a MetricManager.  Each
+// combination of dimension values is aggregated separately, and submitted
+// with the dimensions as properties.  Safe for concurrent use.
+type Metric struct {
+	manager        *MetricManager
+	name           string
+	dimensionNames []string
+	lock           sync.RWMutex
+	series         map[string]*metricSeries
+	capped         int32
+}
+
+// The values aggregated for one combination of dimension values.
+type metricSeries struct {
+	lock            sync.Mutex
+	dimensionValues []string
+	aggregate       *AggregateMetricTelemetry
+	histogram       *MetricHistogram
+	removed         bool
+}
+
+// Gets the name of this metric.
+func (metric *Metric) Name() string {
+	return metric.name
+}
+
+// Gets the names of this metric's dimensions.
+func (metric *Metric) DimensionNames() []string {
+	return append([]string(nil), metric.dimensionNames...)
+}
+
+// Adds a value to the aggregate for the specified dimension values, which
+// must be given in the same order as the metric's dimension names.  Returns
+// false if the value was discarded, because the number of dimension values
+// is wrong or the metric already has MaxSeriesPerMetric combinations.
+func (metric *Metric) TrackValue(value float64, dimensionValues ...string) bool {
+	if len(dimensionValues) != len(metric.dimensionNames) {
+		diagnosticsWriter.Printf("Metric %s has %d dimensions, but %d values were given", metric.name, len(metric.dimensionNames), len(dimensionValues))
+		return false
+	}
+
+	var values [1]float64
+	values[0] = value
+
+	for {
+		series := metric.getSeries(dimensionValues)
+		if series == nil {
+			return false
+		}
+
+		series.lock.Lock()
+		if series.removed {
+			// Collected while idle since we looked it up.
+			series.lock.Unlock()
+			continue
+		}
+
+		if series.aggregate == nil {
+			if len(metric.manager.settings.Percentiles) > 0 {
+				series.histogram = NewMetricHistogram(metric.name)
+				series.aggregate = series.histogram.Aggregate
+			} else {
+				series.aggregate = NewAggregateMetricTelemetry(metric.name)
+			}
+		}
+
+		if series.histogram != nil {
+			series.histogram.AddData(values[:])
+		} else {
+			series.aggregate.AddData(values[:])
+		}
+		series.lock.Unlock()
+		return true
+	}
+}
+
+// Gets the series for the specified dimension values, creating it if
+// necessary.  Returns nil if the metric has too many series already.
+func (metric *Metric) getSeries(dimensionValues []string) *metricSeries {
+	key := strings.Join(dimensionValues, "\x00")
+
+	metric.lock.RLock()
+	series, ok := metric.series[key]
+	metric.lock.RUnlock()
+	if ok {
+		return series
+	}
+
+	metric.lock.Lock()
+	defer metric.lock.Unlock()
+
+	if series, ok := metric.series[key]; ok {
+		return series
+	}
+
+	if len(metric.series) >= metric.manager.settings.MaxSeriesPerMetric {
+		if atomic.CompareAndSwapInt32(&metric.capped, 0, 1) {
+			diagnosticsWriter.Printf("Metric %s has reached the limit of %d dimension combinations; values are being discarded", metric.name, metric.manager.settings.MaxSeriesPerMetric)
+		}
+
+		return nil
+	}
+
+	series = &metricSeries{
+		dimensionValues: append([]string(nil), dimensionValues...),
+	}
+
+	metric.series[key] = series
+	return series
+}
+
+// Takes the values aggregated for each series, and removes series that had
+// none, which makes room for new dimension combinations.  Without
+// percentiles, only the Aggregate of each histogram returned is set.
+func (metric *Metric) collect() []*MetricHistogram {
+	metric.lock.Lock()
+	defer metric.lock.Unlock()
+
+	var result []*MetricHistogram
+	for key, series := range metric.series {
+		series.lock.Lock()
+		histogram := series.histogram
+		if histogram == nil && series.aggregate != nil {
+			histogram = &MetricHistogram{Aggregate: series.aggregate}
+		}
+
+		series.aggregate = nil
+		series.histogram = nil
+		if histogram == nil {
+			series.removed = true
+			delete(metric.series, key)
+		}
+		series.lock.Unlock()
+
+		if histogram != nil {
+			for i, name := range metric.dimensionNames {
+				histogram.Aggregate.Properties[name] = series.dimensionValues[i]
+			}
+
+			result = append(result, histogram)
+		}
+	}
+
+	atomic.StoreInt32(&metric.capped, 0)
+	return result
+}
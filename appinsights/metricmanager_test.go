@@ -0,0 +1,232 @@
+package appinsights
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func metricDataPoints(t *testing.T, items telemetryBufferItems) map[string]*contracts.DataPoint {
+	result := make(map[string]*contracts.DataPoint)
+	for _, envelope := range items {
+		data, ok := envelope.Data.(*contracts.Data).BaseData.(*contracts.MetricData)
+		if !ok {
+			t.Fatalf("Expected metric telemetry, got %s", envelope.Name)
+		}
+
+		dataPoint := data.Metrics[0]
+		if dataPoint.Kind != contracts.Aggregation {
+			t.Errorf("Metric %s is not an aggregation", dataPoint.Name)
+		}
+
+		key := dataPoint.Name
+		if code, ok := data.Properties["code"]; ok {
+			key += "/" + code
+		}
+
+		result[key] = dataPoint
+	}
+
+	return result
+}
+
+func TestMetricManager(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	start := currentClock.Now()
+	manager := NewMetricManager(client, nil)
+	requests := manager.GetMetric("requests", "code")
+	if manager.GetMetric("requests", "code") != requests {
+		t.Error("GetMetric returned a different metric for the same name and dimensions")
+	}
+
+	latency := manager.GetMetric("latency")
+	for i := 1; i <= 3; i++ {
+		requests.TrackValue(float64(i), "200")
+		latency.TrackValue(float64(i * 10))
+	}
+
+	requests.TrackValue(1.0, "500")
+	if requests.TrackValue(1.0) || requests.TrackValue(1.0, "200", "extra") {
+		t.Error("Value with the wrong number of dimensions was accepted")
+	}
+
+	// Nothing is sent until the interval has passed.
+	slowTick(30)
+	transmitter.assertNoRequest(t)
+	slowTick(30)
+
+	manager.Stop()
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 3 {
+		t.Fatalf("Tracked %d items, want 3", len(items))
+	}
+
+	dataPoints := metricDataPoints(t, items)
+	ok := dataPoints["requests/200"]
+	if ok == nil {
+		t.Fatal("requests/200 was not tracked")
+	}
+
+	checkDataContract(t, "Value", ok.Value, 6.0)
+	checkDataContract(t, "Count", ok.Count, 3)
+	checkDataContract(t, "Min", ok.Min, 1.0)
+	checkDataContract(t, "Max", ok.Max, 3.0)
+
+	if dataPoints["requests/500"] == nil || dataPoints["requests/500"].Count != 1 {
+		t.Error("requests/500 was not tracked")
+	}
+
+	if dataPoints["latency"] == nil || dataPoints["latency"].Value != 60.0 {
+		t.Error("latency was not tracked")
+	}
+
+	for _, envelope := range items {
+		data := envelope.Data.(*contracts.Data).BaseData.(*contracts.MetricData)
+		checkDataContract(t, "Interval", data.Properties[metricAggregationIntervalProperty], "60000")
+		if envelope.Time != start.UTC().Format(time.RFC3339Nano) {
+			t.Errorf("Timestamp is %s, want the start of the interval", envelope.Time)
+		}
+	}
+}
+
+func TestMetricManagerSkipsIdleSeries(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	manager := NewMetricManager(client, nil)
+	metric := manager.GetMetric("requests", "code")
+	metric.TrackValue(1.0, "200")
+	manager.Flush()
+
+	metric.TrackValue(2.0, "500")
+	manager.Flush()
+	manager.Flush()
+	manager.Stop()
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 2 {
+		t.Fatalf("Tracked %d items, want 2", len(items))
+	}
+}
+
+func TestMetricManagerSeriesLimit(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	var messages []string
+	NewDiagnosticsMessageListener(func(msg string) error {
+		if strings.Contains(msg, "dimension combinations") {
+			messages = append(messages, msg)
+		}
+
+		return nil
+	})
+	defer resetDiagnosticsListeners()
+
+	settings := NewMetricSettings()
+	settings.MaxSeriesPerMetric = 2
+	manager := NewMetricManager(client, settings)
+	metric := manager.GetMetric("requests", "code")
+
+	if !metric.TrackValue(1.0, "200") || !metric.TrackValue(1.0, "404") {
+		t.Fatal("Values under the limit were discarded")
+	}
+
+	if metric.TrackValue(1.0, "500") || metric.TrackValue(1.0, "503") {
+		t.Error("Values over the limit were accepted")
+	}
+
+	if !metric.TrackValue(1.0, "200") {
+		t.Error("Value for an existing combination was discarded")
+	}
+
+	if len(messages) != 1 {
+		t.Errorf("Unexpected diagnostics: %q", messages)
+	}
+
+	// Once a combination goes unused, there is room for another.
+	manager.Flush()
+	metric.TrackValue(1.0, "200")
+	manager.Flush()
+	if !metric.TrackValue(1.0, "500") {
+		t.Error("Value was discarded after room was made")
+	}
+
+	manager.Stop()
+	items := closeAndGetItems(t, client, transmitter)
+	dataPoints := metricDataPoints(t, items)
+	if len(items) != 4 || dataPoints["requests/500"] == nil {
+		t.Errorf("Unexpected items: %d", len(items))
+	}
+}
+
+func TestMetricManagerPercentiles(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	settings := NewMetricSettings()
+	settings.Percentiles = []float64{50, 95}
+	manager := NewMetricManager(client, settings)
+	metric := manager.GetMetric("latency", "code")
+	for i := 1; i <= 100; i++ {
+		metric.TrackValue(float64(i), "200")
+	}
+
+	manager.Stop()
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 3 {
+		t.Fatalf("Tracked %d items, want 3", len(items))
+	}
+
+	dataPoints := metricDataPoints(t, items[:1])
+	checkDataContract(t, "Count", dataPoints["latency/200"].Count, 100)
+
+	for i, name := range []string{"latency_p50", "latency_p95"} {
+		data := items[i+1].Data.(*contracts.Data).BaseData.(*contracts.MetricData)
+		checkDataContract(t, "Name", data.Metrics[0].Name, name)
+		checkDataContract(t, "Kind", data.Metrics[0].Kind, contracts.Measurement)
+		checkDataContract(t, "Properties[code]", data.Properties["code"], "200")
+		if _, ok := data.Properties[metricAggregationIntervalProperty]; ok {
+			t.Errorf("%s has an aggregation interval", name)
+		}
+	}
+}
+
+func TestMetricManagerPartialSettings(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	manager := NewMetricManager(client, &MetricSettings{Percentiles: []float64{50}})
+	defaults := NewMetricSettings()
+	checkDataContract(t, "AggregationInterval", manager.settings.AggregationInterval, defaults.AggregationInterval)
+	checkDataContract(t, "MaxSeriesPerMetric", manager.settings.MaxSeriesPerMetric, defaults.MaxSeriesPerMetric)
+
+	metric := manager.GetMetric("latency")
+	if !metric.TrackValue(1.0) {
+		t.Error("Value was rejected")
+	}
+
+	// Nothing is sent until the default interval has passed.
+	slowTick(30)
+	transmitter.assertNoRequest(t)
+
+	manager.Stop()
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 2 {
+		t.Fatalf("Tracked %d items, want 2", len(items))
+	}
+}
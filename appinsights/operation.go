@@ -0,0 +1,161 @@
+package appinsights
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// An Operation groups the telemetry that results from a single unit of
+// work, such as an incoming HTTP request or a call to a dependency.
+// Telemetry tracked through the operation carries its operation ID and
+// names the operation's own telemetry item as its parent, so that it can be
+// correlated in the portal.  Operations are started with
+// TelemetryClient.StartRequestOperation and StartDependencyOperation, and
+// their telemetry is submitted by End.
+type Operation struct {
+	children    int32
+	ended       int32
+	start       time.Time
+	client      TelemetryClient
+	telemetry   Telemetry
+	id          string
+	operationId string
+	parentId    string
+	name        string
+	traceFlags  byte
+	traceState  string
+	baggage     map[string]string
+}
+
+type operationContextKey struct{}
+
+// Creates an operation that will submit the specified telemetry item, as a
+// child of parent if it is not nil or as the root of a new end-to-end
+// operation otherwise.  The caller must set the operation's id.
+func newOperation(client TelemetryClient, parent *Operation, telemetry Telemetry, name string) *Operation {
+	operation := &Operation{
+		start:     currentClock.Now(),
+		client:    client,
+		telemetry: telemetry,
+		name:      name,
+	}
+
+	if parent != nil {
+		operation.operationId = parent.operationId
+		operation.parentId = parent.id
+		operation.traceFlags = parent.traceFlags
+		operation.traceState = parent.traceState
+		operation.baggage = parent.baggage
+		if operation.name == "" {
+			operation.name = parent.name
+		}
+	} else {
+		operation.operationId = newTraceId()
+		operation.traceFlags = traceFlagSampled
+	}
+
+	return operation
+}
+
+// Gets the operation associated with the specified context, or nil if
+// there is none.
+func OperationFromContext(ctx context.Context) *Operation {
+	if ctx == nil {
+		return nil
+	}
+
+	operation, _ := ctx.Value(operationContextKey{}).(*Operation)
+	return operation
+}
+
+// Returns a copy of ctx associated with the specified operation.
+func contextWithOperation(ctx context.Context, operation *Operation) context.Context {
+	if ctx == nil {
+		ctx = backgroundContext
+	}
+
+	return context.WithValue(ctx, operationContextKey{}, operation)
+}
+
+// Gets the identifier of the operation's own telemetry item, such as the Id
+// of its RequestTelemetry.  Telemetry tracked as part of the operation
+// names this as its parent.
+func (operation *Operation) Id() string {
+	return operation.id
+}
+
+// Gets the ID shared by all telemetry in the end-to-end operation.
+func (operation *Operation) OperationId() string {
+	return operation.operationId
+}
+
+// Gets the identifier of the telemetry item that caused this operation, if
+// any.
+func (operation *Operation) ParentId() string {
+	return operation.parentId
+}
+
+// Gets the name of the operation.
+func (operation *Operation) Name() string {
+	return operation.name
+}
+
+// Gets the telemetry item that will be tracked when the operation
+// completes.  Properties and tags set on it will be submitted.
+func (operation *Operation) Telemetry() Telemetry {
+	return operation.telemetry
+}
+
+// Submits the specified telemetry item as part of this operation.
+func (operation *Operation) Track(item Telemetry) {
+	if item == nil {
+		return
+	}
+
+	operation.correlate(item.ContextTags(), operation.id)
+	operation.client.Track(item)
+}
+
+// Completes the operation: sets the timestamp and duration of its telemetry
+// from the time it was started, and submits it.  Calls after the first have
+// no effect.
+func (operation *Operation) End() {
+	if !atomic.CompareAndSwapInt32(&operation.ended, 0, 1) {
+		return
+	}
+
+	if timed, ok := operation.telemetry.(interface {
+		MarkTime(startTime, endTime time.Time)
+	}); ok {
+		timed.MarkTime(operation.start, currentClock.Now())
+	}
+
+	operation.trackSelf()
+}
+
+// Submits the operation's own telemetry item.
+func (operation *Operation) trackSelf() {
+	operation.correlate(operation.telemetry.ContextTags(), operation.parentId)
+	operation.client.Track(operation.telemetry)
+}
+
+// Sets the operation tags on a telemetry item, unless they are already
+// present.
+func (operation *Operation) correlate(tags map[string]string, parentId string) {
+	if tags == nil {
+		return
+	}
+
+	setDefaultTag(tags, contracts.OperationId, operation.operationId)
+	setDefaultTag(tags, contracts.OperationParentId, parentId)
+	setDefaultTag(tags, contracts.OperationName, operation.name)
+}
+
+func setDefaultTag(tags map[string]string, key, value string) {
+	if _, ok := tags[key]; !ok && value != "" {
+		tags[key] = value
+	}
+}
@@ -0,0 +1,94 @@
+package appinsights
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func TestStartOperations(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	ctx, request := client.StartRequestOperation(context.Background(), "ProcessMessage")
+	if OperationFromContext(ctx) != request {
+		t.Error("Context does not carry the request operation")
+	}
+
+	fakeClock.Increment(time.Second)
+	depCtx, dependency := client.StartDependencyOperation(ctx, "SELECT", "SQL", "db")
+	if OperationFromContext(depCtx) != dependency {
+		t.Error("Context does not carry the dependency operation")
+	}
+
+	OperationFromContext(depCtx).Track(NewTraceTelemetry("~query~", Information))
+
+	fakeClock.Increment(2 * time.Second)
+	dependency.End()
+	dependency.End()
+
+	fakeClock.Increment(time.Second)
+	request.Telemetry().(*RequestTelemetry).ResponseCode = "500"
+	request.End()
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 3 {
+		t.Fatalf("Tracked %d items, want 3", len(items))
+	}
+
+	trace, dep, req := items[0], items[1], items[2]
+	reqData := requestData(t, req)
+	depData := dependencyData(t, dep)
+
+	checkDataContract(t, "request Name", reqData.Name, "ProcessMessage")
+	checkDataContract(t, "request Id", reqData.Id, request.Id())
+	checkDataContract(t, "request Duration", reqData.Duration, "0.00:00:04.0000000")
+	checkDataContract(t, "request ResponseCode", reqData.ResponseCode, "500")
+	checkDataContract(t, "request ai.operation.id", req.Tags[contracts.OperationId], request.OperationId())
+	checkDataContract(t, "request ai.operation.name", req.Tags[contracts.OperationName], "ProcessMessage")
+	if _, ok := req.Tags[contracts.OperationParentId]; ok {
+		t.Error("Root request should not have a parent")
+	}
+
+	checkDataContract(t, "dependency Name", depData.Name, "SELECT")
+	checkDataContract(t, "dependency Type", depData.Type, "SQL")
+	checkDataContract(t, "dependency Target", depData.Target, "db")
+	checkDataContract(t, "dependency Id", depData.Id, dependency.Id())
+	checkDataContract(t, "dependency Success", depData.Success, true)
+	checkDataContract(t, "dependency Duration", depData.Duration, "0.00:00:02.0000000")
+	checkDataContract(t, "dependency ai.operation.id", dep.Tags[contracts.OperationId], request.OperationId())
+	checkDataContract(t, "dependency ai.operation.parentId", dep.Tags[contracts.OperationParentId], request.Id())
+	checkDataContract(t, "dependency ai.operation.name", dep.Tags[contracts.OperationName], "ProcessMessage")
+
+	checkDataContract(t, "trace ai.operation.id", trace.Tags[contracts.OperationId], request.OperationId())
+	checkDataContract(t, "trace ai.operation.parentId", trace.Tags[contracts.OperationParentId], dependency.Id())
+
+	if !isTraceId(request.OperationId()) {
+		t.Errorf("Operation ID is not a trace-id: %q", request.OperationId())
+	}
+}
+
+func TestStartDependencyOperationWithoutParent(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	_, dependency := client.StartDependencyOperation(context.Background(), "GET /", "HTTP", "example.com")
+	dependency.End()
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 1 {
+		t.Fatalf("Tracked %d items, want 1", len(items))
+	}
+
+	dep := items[0]
+	checkDataContract(t, "ai.operation.id", dep.Tags[contracts.OperationId], dependency.OperationId())
+	if _, ok := dep.Tags[contracts.OperationParentId]; ok {
+		t.Error("Root dependency should not have a parent")
+	}
+}
@@ -0,0 +1,201 @@
+package appinsights
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Names of the metrics submitted by PerformanceCollector.  Counts of events
+// such as allocations are the number that occurred since the previous
+// sample, so the aggregate's sum is the number in the interval.
+const (
+	perfGoroutines        = "go.goroutines"
+	perfCgoCalls          = "go.cgo_calls"
+	perfHeapAllocBytes    = "go.memory.heap_alloc_bytes"
+	perfHeapSysBytes      = "go.memory.heap_sys_bytes"
+	perfHeapObjects       = "go.memory.heap_objects"
+	perfSysBytes          = "go.memory.sys_bytes"
+	perfMallocs           = "go.memory.mallocs"
+	perfFrees             = "go.memory.frees"
+	perfGCCount           = "go.gc.count"
+	perfGCPauseMs         = "go.gc.pause_ms"
+	perfProcessCpuPercent = "go.process.cpu_percent"
+	perfProcessRssBytes   = "go.process.resident_bytes"
+)
+
+const (
+	// Default interval between samples.
+	defaultPerfSampleInterval = time.Duration(10) * time.Second
+)
+
+// Percentiles estimated for the distribution of GC pauses.
+var perfGCPausePercentiles = []float64{50, 95, 99}
+
+// Settings used to initialize a new PerformanceCollector.
+type PerformanceCollectorSettings struct {
+	// How often the runtime and process are sampled.
+	SampleInterval time.Duration
+
+	// How often the aggregated samples are submitted.
+	AggregationInterval time.Duration
+}
+
+// Creates a new PerformanceCollectorSettings object with default values.
+func NewPerformanceCollectorSettings() *PerformanceCollectorSettings {
+	return &PerformanceCollectorSettings{
+		SampleInterval:      defaultPerfSampleInterval,
+		AggregationInterval: time.Duration(60) * time.Second,
+	}
+}
+
+// Periodically samples the health of the Go runtime and the process, and
+// submits the samples as AggregateMetricTelemetry through a MetricManager.
+// Metric names all begin with "go.": goroutines, cgo calls, heap and
+// system memory, allocations, garbage collections and the duration of each
+// GC pause, with its 50th, 95th and 99th percentiles.  On Linux, process
+// CPU usage and resident memory are read from /proc/self as well.
+type PerformanceCollector struct {
+	settings  PerformanceCollectorSettings
+	metrics   *MetricManager
+	pauses    *MetricManager
+	stopOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+	lastStats runtime.MemStats
+	lastCgo   int64
+	lastCpu   processCpuSample
+}
+
+// Creates a PerformanceCollector that submits to the specified client, and
+// starts sampling in a background goroutine.  The collector stops when the
+// client's channel is closed or stopped, after submitting what it has
+// aggregated.  If settings is nil, the defaults from
+// NewPerformanceCollectorSettings are used; likewise for any interval that
+// is not positive.
+func NewPerformanceCollector(client TelemetryClient, settings *PerformanceCollectorSettings) *PerformanceCollector {
+	if settings == nil {
+		settings = NewPerformanceCollectorSettings()
+	}
+
+	validated := *settings
+	if validated.SampleInterval <= 0 {
+		validated.SampleInterval = defaultPerfSampleInterval
+	}
+
+	metricSettings := NewMetricSettings()
+	metricSettings.AggregationInterval = validated.AggregationInterval
+
+	// Only the pause distribution is worth the extra percentile items.
+	pauseSettings := NewMetricSettings()
+	pauseSettings.AggregationInterval = validated.AggregationInterval
+	pauseSettings.Percentiles = perfGCPausePercentiles
+
+	collector := &PerformanceCollector{
+		settings: validated,
+		metrics:  NewMetricManager(client, metricSettings),
+		pauses:   NewMetricManager(client, pauseSettings),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		lastCgo:  runtime.NumCgoCall(),
+		lastCpu:  readProcessCpu(),
+	}
+
+	runtime.ReadMemStats(&collector.lastStats)
+
+	if channel, ok := client.Channel().(closeHookChannel); ok {
+		channel.onClose(collector.Stop)
+	}
+
+	go collector.sampleLoop()
+
+	return collector
+}
+
+// Stops sampling, and submits the samples aggregated so far.  Called
+// automatically when the client's channel is closed or stopped.
+func (collector *PerformanceCollector) Stop() {
+	collector.stopOnce.Do(func() {
+		close(collector.stop)
+		<-collector.done
+		collector.metrics.Stop()
+		collector.pauses.Stop()
+	})
+}
+
+func (collector *PerformanceCollector) sampleLoop() {
+	defer close(collector.done)
+
+	timer := currentClock.NewTimer(collector.settings.SampleInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-collector.stop:
+			return
+		case <-timer.C():
+			collector.sample()
+			timer.Reset(collector.settings.SampleInterval)
+		}
+	}
+}
+
+// Takes one sample of each counter.
+func (collector *PerformanceCollector) sample() {
+	metrics := collector.metrics
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	last := &collector.lastStats
+
+	metrics.GetMetric(perfGoroutines).TrackValue(float64(runtime.NumGoroutine()))
+	metrics.GetMetric(perfHeapAllocBytes).TrackValue(float64(stats.HeapAlloc))
+	metrics.GetMetric(perfHeapSysBytes).TrackValue(float64(stats.HeapSys))
+	metrics.GetMetric(perfHeapObjects).TrackValue(float64(stats.HeapObjects))
+	metrics.GetMetric(perfSysBytes).TrackValue(float64(stats.Sys))
+	metrics.GetMetric(perfMallocs).TrackValue(float64(stats.Mallocs - last.Mallocs))
+	metrics.GetMetric(perfFrees).TrackValue(float64(stats.Frees - last.Frees))
+	metrics.GetMetric(perfGCCount).TrackValue(float64(stats.NumGC - last.NumGC))
+
+	// PauseNs holds the most recent pauses in a circular buffer; older
+	// ones have been overwritten if there were too many since the last
+	// sample.
+	pauses := stats.NumGC - last.NumGC
+	if max := uint32(len(stats.PauseNs)); pauses > max {
+		pauses = max
+	}
+
+	pauseMetric := collector.pauses.GetMetric(perfGCPauseMs)
+	for i := uint32(0); i < pauses; i++ {
+		pause := stats.PauseNs[(stats.NumGC-1-i)%uint32(len(stats.PauseNs))]
+		pauseMetric.TrackValue(float64(pause) / float64(time.Millisecond))
+	}
+
+	cgo := runtime.NumCgoCall()
+	metrics.GetMetric(perfCgoCalls).TrackValue(float64(cgo - collector.lastCgo))
+
+	cpu := readProcessCpu()
+	if cpu.valid && collector.lastCpu.valid {
+		elapsed := cpu.timestamp.Sub(collector.lastCpu.timestamp)
+		if elapsed > 0 {
+			used := cpu.cpuTime - collector.lastCpu.cpuTime
+			percent := 100.0 * float64(used) / float64(elapsed) / float64(runtime.NumCPU())
+			metrics.GetMetric(perfProcessCpuPercent).TrackValue(percent)
+		}
+	}
+
+	if rss, ok := readProcessResidentBytes(); ok {
+		metrics.GetMetric(perfProcessRssBytes).TrackValue(float64(rss))
+	}
+
+	collector.lastStats = stats
+	collector.lastCgo = cgo
+	collector.lastCpu = cpu
+}
+
+// CPU time used by the process, and when it was measured.
+type processCpuSample struct {
+	valid     bool
+	timestamp time.Time
+	cpuTime   time.Duration
+}
@@ -0,0 +1,66 @@
+package appinsights
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clock ticks per second used by /proc/self/stat.  This is USER_HZ, which
+// is 100 on every architecture Go supports.
+const procClockTicks = 100
+
+// Reads the CPU time used by the process from /proc/self/stat.
+func readProcessCpu() processCpuSample {
+	data, err := ioutil.ReadFile("/proc/self/stat")
+	if err != nil {
+		return processCpuSample{}
+	}
+
+	// The command name may contain spaces, so skip past it first.  The
+	// fields after it start with the state (field 3); utime and stime
+	// are fields 14 and 15.
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 13 {
+		return processCpuSample{}
+	}
+
+	utime, err := strconv.ParseInt(fields[11], 10, 64)
+	if err != nil {
+		return processCpuSample{}
+	}
+
+	stime, err := strconv.ParseInt(fields[12], 10, 64)
+	if err != nil {
+		return processCpuSample{}
+	}
+
+	return processCpuSample{
+		valid:     true,
+		timestamp: currentClock.Now(),
+		cpuTime:   time.Duration(utime+stime) * time.Second / procClockTicks,
+	}
+}
+
+// Reads the resident set size of the process from /proc/self/statm.
+func readProcessResidentBytes() (int64, bool) {
+	data, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, false
+	}
+
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return pages * int64(os.Getpagesize()), true
+}
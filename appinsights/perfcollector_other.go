@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package appinsights
+
+// Process CPU usage is only collected on Linux.
+func readProcessCpu() processCpuSample {
+	return processCpuSample{}
+}
+
+// Resident memory is only collected on Linux.
+func readProcessResidentBytes() (int64, bool) {
+	return 0, false
+}
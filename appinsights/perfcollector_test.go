@@ -0,0 +1,131 @@
+package appinsights
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func TestPerformanceCollector(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	collector := NewPerformanceCollector(client, nil)
+	slowTick(10)
+	runtime.GC()
+	slowTick(10)
+
+	// Closing the channel stops the collector and submits what it has.
+	items := closeAndGetItems(t, client, transmitter)
+	select {
+	case <-collector.done:
+	default:
+		t.Error("Collector is still sampling")
+	}
+
+	// Percentiles of the pause distribution are plain measurements.
+	var aggregates telemetryBufferItems
+	percentiles := make(map[string]bool)
+	for _, envelope := range items {
+		data := envelope.Data.(*contracts.Data).BaseData.(*contracts.MetricData)
+		if data.Metrics[0].Kind == contracts.Measurement {
+			percentiles[data.Metrics[0].Name] = true
+		} else {
+			aggregates = append(aggregates, envelope)
+		}
+	}
+
+	dataPoints := make(map[string]*contracts.DataPoint)
+	for _, dataPoint := range metricDataPoints(t, aggregates) {
+		dataPoints[dataPoint.Name] = dataPoint
+	}
+
+	for _, name := range []string{perfGoroutines, perfCgoCalls, perfHeapAllocBytes, perfHeapSysBytes, perfHeapObjects, perfSysBytes, perfMallocs, perfFrees, perfGCCount} {
+		if dataPoint, ok := dataPoints[name]; !ok {
+			t.Errorf("%s was not tracked", name)
+		} else if dataPoint.Count != 2 {
+			t.Errorf("%s has %d samples, want 2", name, dataPoint.Count)
+		}
+	}
+
+	if dataPoints[perfGoroutines].Min < 1.0 || dataPoints[perfHeapAllocBytes].Min <= 0.0 {
+		t.Error("Runtime counters are empty")
+	}
+
+	if dataPoints[perfGCCount].Value < 1.0 || dataPoints[perfGCPauseMs] == nil || dataPoints[perfGCPauseMs].Count < 1 {
+		t.Error("Garbage collection was not tracked")
+	}
+
+	for _, name := range []string{"go.gc.pause_ms_p50", "go.gc.pause_ms_p95", "go.gc.pause_ms_p99"} {
+		if !percentiles[name] {
+			t.Errorf("%s was not tracked", name)
+		}
+	}
+
+	if len(percentiles) != 3 {
+		t.Errorf("Tracked %d percentiles, want 3", len(percentiles))
+	}
+
+	if runtime.GOOS == "linux" {
+		if dataPoint, ok := dataPoints[perfProcessRssBytes]; !ok || dataPoint.Min <= 0.0 {
+			t.Errorf("%s was not tracked", perfProcessRssBytes)
+		}
+
+		if dataPoint, ok := dataPoints[perfProcessCpuPercent]; !ok || dataPoint.Count != 2 {
+			t.Errorf("%s was not tracked", perfProcessCpuPercent)
+		}
+	}
+}
+
+func TestPerformanceCollectorStop(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	collector := NewPerformanceCollector(client, nil)
+	collector.Stop()
+	slowTick(60)
+
+	// Nothing was sampled, so nothing is submitted.
+	transmitter.prepResponse(200)
+	waitForClose(t, client.Channel().Close())
+	transmitter.assertNoRequest(t)
+}
+
+func TestPerformanceCollectorDefaultsSampleInterval(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	collector := NewPerformanceCollector(client, &PerformanceCollectorSettings{})
+	if collector.settings.SampleInterval != defaultPerfSampleInterval {
+		t.Errorf("SampleInterval is %s, want %s", collector.settings.SampleInterval, defaultPerfSampleInterval)
+	}
+
+	if collector.metrics.settings.AggregationInterval <= 0 || collector.pauses.settings.AggregationInterval <= 0 {
+		t.Error("AggregationInterval was not defaulted")
+	}
+
+	waitForClose(t, client.Channel().Close())
+}
+
+func TestReadProcessStats(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Process statistics are only read on Linux")
+	}
+
+	before := readProcessCpu()
+	after := readProcessCpu()
+	if !before.valid || !after.valid || after.cpuTime < before.cpuTime {
+		t.Errorf("Unexpected CPU samples: %+v, %+v", before, after)
+	}
+
+	if rss, ok := readProcessResidentBytes(); !ok || rss <= 0 {
+		t.Errorf("Unexpected resident size: %d", rss)
+	}
+}
@@ -0,0 +1,195 @@
+package appinsights
+
+import (
+	"errors"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+var (
+	storage_replay_interval = time.Duration(30 * time.Second)
+)
+
+// A telemetry channel that buffers events in memory like InMemoryChannel,
+// but writes each batch to disk before it is transmitted.  Stored batches
+// are deleted only once the data collector has accepted them (or rejected
+// them outright), so telemetry that could not be sent before the process
+// exited is replayed by the next PersistentChannel created on the same
+// directory.
+type PersistentChannel struct {
+	channel    *InMemoryChannel
+	storage    *diskStorage
+	replayStop chan struct{}
+	replayDone chan struct{}
+}
+
+// Creates a PersistentChannel instance that stores telemetry in
+// config.StorageDirectory, and starts background goroutines to submit new
+// telemetry and replay any telemetry left over from a previous run.
+func NewPersistentChannel(config *TelemetryConfiguration) (*PersistentChannel, error) {
+	return newPersistentChannel(config, newTransmitter(config.EndpointUrl, config.Client))
+}
+
+func newPersistentChannel(config *TelemetryConfiguration, transmitter transmitter) (*PersistentChannel, error) {
+	if config.StorageDirectory == "" {
+		return nil, errors.New("TelemetryConfiguration.StorageDirectory is not set")
+	}
+
+	storage, err := newDiskStorage(config.StorageDirectory, config.MaxStorageSize, config.MaxStorageAge)
+	if err != nil {
+		return nil, err
+	}
+
+	channel := &PersistentChannel{
+		channel:    newInMemoryChannel(config, transmitter, storage),
+		storage:    storage,
+		replayStop: make(chan struct{}),
+		replayDone: make(chan struct{}),
+	}
+
+	go channel.replayLoop()
+
+	return channel, nil
+}
+
+// The address of the endpoint to which telemetry is sent
+func (channel *PersistentChannel) EndpointAddress() string {
+	return channel.channel.EndpointAddress()
+}
+
+// Queues a single telemetry item
+func (channel *PersistentChannel) Send(item *contracts.Envelope) {
+	channel.channel.Send(item)
+}
+
+// Forces the current queue to be sent
+func (channel *PersistentChannel) Flush() {
+	channel.channel.Flush()
+}
+
+func (channel *PersistentChannel) flushCallback(immediate bool) <-chan struct{} {
+	return channel.channel.flushCallback(immediate)
+}
+
+// Tears down the submission goroutines, closes internal channels.  Any
+// telemetry waiting to be sent is discarded.  Telemetry that was already
+// written to disk remains there to be replayed later.  Further calls to
+// Send() have undefined behavior.  This is a more abrupt version of Close().
+func (channel *PersistentChannel) Stop() {
+	channel.channel.runCloseHooks()
+	if channel.stopReplay() {
+		go func() {
+			// The replay loop shares the in-memory channel's
+			// throttle, so it must finish before the channel stops.
+			<-channel.replayDone
+			channel.channel.Stop()
+		}()
+	}
+}
+
+// Returns the number of telemetry items that have been discarded because
+// the queue was full or the channel was throttled.
+func (channel *PersistentChannel) DroppedItems() int64 {
+	return channel.channel.DroppedItems()
+}
+
+// Returns true if this channel has been throttled by the data collector.
+func (channel *PersistentChannel) IsThrottled() bool {
+	return channel.channel.IsThrottled()
+}
+
+// Flushes and tears down the submission goroutine and closes internal
+// channels.  Returns a channel that is closed when all pending telemetry
+// items have been submitted or left in storage.  See
+// InMemoryChannel.Close for the meaning of retryTimeout.
+func (channel *PersistentChannel) Close(retryTimeout ...time.Duration) <-chan struct{} {
+	channel.channel.runCloseHooks()
+	if !channel.stopReplay() {
+		return nil
+	}
+
+	callback := make(chan struct{})
+	go func() {
+		<-channel.replayDone
+		if ch := channel.channel.Close(retryTimeout...); ch != nil {
+			<-ch
+		}
+
+		close(callback)
+	}()
+
+	return callback
+}
+
+func (channel *PersistentChannel) lastTransmitSucceeded() (succeeded, ok bool) {
+	return channel.channel.lastTransmitSucceeded()
+}
+
+func (channel *PersistentChannel) onClose(f func()) {
+	channel.channel.onClose(f)
+}
+
+// Signals the replay loop to stop.  Returns false if it was already
+// signaled.
+func (channel *PersistentChannel) stopReplay() bool {
+	select {
+	case <-channel.replayStop:
+		return false
+	default:
+		close(channel.replayStop)
+		return true
+	}
+}
+
+func (channel *PersistentChannel) replayLoop() {
+	defer close(channel.replayDone)
+
+	timer := currentClock.NewTimer(storage_replay_interval)
+	defer timer.Stop()
+
+	for {
+		channel.replay()
+
+		select {
+		case <-channel.replayStop:
+			return
+		case <-timer.C():
+			timer.Reset(storage_replay_interval)
+		}
+	}
+}
+
+// Submits stored telemetry that is not currently in-flight, oldest first.
+// Gives up on the first failure, leaving the rest for the next attempt.
+func (channel *PersistentChannel) replay() {
+	for _, name := range channel.storage.pending() {
+		select {
+		case <-channel.replayStop:
+			return
+		default:
+		}
+
+		if channel.IsThrottled() {
+			return
+		}
+
+		if !channel.storage.claim(name) {
+			continue
+		}
+
+		payload, items, err := channel.storage.load(name)
+		if err != nil {
+			diagnosticsWriter.Printf("Failed to read stored telemetry: %s", err.Error())
+			continue
+		}
+
+		diagnosticsWriter.Printf("Replaying %d stored telemetry items", len(items))
+		remainingPayload, remainingItems := channel.channel.transmitPayload(payload, items, false, 0)
+		channel.storage.settle(name, len(items), remainingPayload, remainingItems)
+
+		if len(remainingItems) > 0 {
+			return
+		}
+	}
+}
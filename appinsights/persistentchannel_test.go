@@ -0,0 +1,342 @@
+package appinsights
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPersistentChannelServer(t *testing.T, directory string) (TelemetryClient, *testTransmitter) {
+	transmitter := &testTransmitter{
+		requests:  make(chan *testTransmission, 16),
+		responses: make(chan *transmissionResult, 16),
+	}
+
+	config := NewTelemetryConfiguration("")
+	config.MaxBatchInterval = ten_seconds
+	config.StorageDirectory = directory
+
+	channel, err := newPersistentChannel(config, transmitter)
+	if err != nil {
+		t.Fatalf("Failed to create persistent channel: %s", err.Error())
+	}
+
+	client := &telemetryClient{
+		channel:   channel,
+		context:   config.setupContext(),
+		isEnabled: true,
+	}
+
+	return client, transmitter
+}
+
+func newTestStorageDirectory(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "appinsights")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err.Error())
+	}
+
+	return directory
+}
+
+func waitForStoredFiles(t *testing.T, directory string, count int) {
+	for i := 0; i < 100; i++ {
+		infos, err := ioutil.ReadDir(directory)
+		if err != nil {
+			t.Fatalf("Failed to read storage directory: %s", err.Error())
+		}
+
+		n := 0
+		for _, info := range infos {
+			if strings.HasSuffix(info.Name(), storageFileExtension) {
+				n++
+			}
+		}
+
+		if n == count {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Storage directory did not contain %d files", count)
+}
+
+func TestPersistentChannelRemovesAcceptedTelemetry(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	directory := newTestStorageDirectory(t)
+	defer os.RemoveAll(directory)
+
+	client, transmitter := newTestPersistentChannelServer(t, directory)
+	defer transmitter.Close()
+	defer client.Channel().Stop()
+
+	client.TrackTrace("~msg~", Information)
+	client.Channel().Flush()
+
+	req := transmitter.waitForRequest(t)
+	if !strings.Contains(req.payload, "~msg~") {
+		t.Error("Unexpected payload")
+	}
+
+	// Stored until the response comes back
+	waitForStoredFiles(t, directory, 1)
+	transmitter.prepResponse(200)
+	waitForStoredFiles(t, directory, 0)
+}
+
+func TestPersistentChannelReplaysOnStartup(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	directory := newTestStorageDirectory(t)
+	defer os.RemoveAll(directory)
+
+	client, transmitter := newTestPersistentChannelServer(t, directory)
+	defer transmitter.Close()
+
+	transmitter.prepResponse(503)
+	client.TrackTrace("~unsent~", Information)
+	waitForClose(t, client.Channel().Close())
+
+	transmitter.waitForRequest(t)
+	waitForStoredFiles(t, directory, 1)
+
+	// A new channel on the same directory should pick it up.
+	client2, transmitter2 := newTestPersistentChannelServer(t, directory)
+	defer transmitter2.Close()
+
+	transmitter2.prepResponse(200)
+
+	req := transmitter2.waitForRequest(t)
+	if !strings.Contains(req.payload, "~unsent~") || len(req.items) != 1 {
+		t.Error("Unexpected payload")
+	}
+
+	waitForStoredFiles(t, directory, 0)
+
+	// Wait for the channel to shut down before the clock is reset.
+	waitForClose(t, client2.Channel().Close())
+}
+
+func TestPersistentChannelKeepsRetryableItems(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	directory := newTestStorageDirectory(t)
+	defer os.RemoveAll(directory)
+
+	client, transmitter := newTestPersistentChannelServer(t, directory)
+	defer transmitter.Close()
+
+	client.TrackTrace("~ok~", Information)
+	client.TrackTrace("~retry~", Information)
+	client.TrackTrace("~bad~", Information)
+
+	transmitter.responses <- &transmissionResult{
+		statusCode: 206,
+		response: &backendResponse{
+			ItemsAccepted: 1,
+			ItemsReceived: 3,
+			Errors: []*itemTransmissionResult{
+				&itemTransmissionResult{Index: 1, StatusCode: 500, Message: "Server Error"},
+				&itemTransmissionResult{Index: 2, StatusCode: 400, Message: "Bad Request"},
+			},
+		},
+	}
+
+	waitForClose(t, client.Channel().Close())
+	transmitter.waitForRequest(t)
+	waitForStoredFiles(t, directory, 1)
+
+	client2, transmitter2 := newTestPersistentChannelServer(t, directory)
+	defer transmitter2.Close()
+
+	transmitter2.prepResponse(200)
+
+	req := transmitter2.waitForRequest(t)
+	if len(req.items) != 1 || !strings.Contains(req.payload, "~retry~") {
+		t.Error("Unexpected payload")
+	}
+
+	waitForStoredFiles(t, directory, 0)
+	waitForClose(t, client2.Channel().Close())
+}
+
+func TestPersistentChannelReplayHonorsRetryAfter(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	directory := newTestStorageDirectory(t)
+	defer os.RemoveAll(directory)
+
+	client, transmitter := newTestPersistentChannelServer(t, directory)
+	defer transmitter.Close()
+
+	transmitter.prepResponse(503)
+	client.TrackTrace("~unsent~", Information)
+	waitForClose(t, client.Channel().Close())
+
+	transmitter.waitForRequest(t)
+	waitForStoredFiles(t, directory, 1)
+
+	client2, transmitter2 := newTestPersistentChannelServer(t, directory)
+	defer transmitter2.Close()
+
+	retryAfter := currentClock.Now().Add(2 * time.Minute)
+	transmitter2.responses <- &transmissionResult{
+		statusCode: 429,
+		retryAfter: &retryAfter,
+	}
+
+	transmitter2.waitForRequest(t)
+
+	// Several replay intervals pass, but Retry-After has not.
+	slowTick(90)
+	transmitter2.assertNoRequest(t)
+	waitForStoredFiles(t, directory, 1)
+
+	transmitter2.prepResponse(200)
+	slowTick(60)
+
+	req := transmitter2.waitForRequest(t)
+	if !req.timestamp.After(retryAfter) && !req.timestamp.Equal(retryAfter) {
+		t.Error("Replay was retried before Retry-After expired")
+	}
+
+	if !strings.Contains(req.payload, "~unsent~") {
+		t.Error("Unexpected payload")
+	}
+
+	waitForStoredFiles(t, directory, 0)
+	waitForClose(t, client2.Channel().Close())
+}
+
+func TestDiskStorageLimits(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	directory := newTestStorageDirectory(t)
+	defer os.RemoveAll(directory)
+
+	storage, err := newDiskStorage(directory, 25, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %s", err.Error())
+	}
+
+	payload := []byte("0123456789")
+	first := storage.save(payload)
+	fakeClock.Increment(time.Minute)
+	second := storage.save(payload)
+	storage.settle(first, 1, payload, telemetryBuffer(NewEventTelemetry("first")))
+	storage.settle(second, 1, payload, telemetryBuffer(NewEventTelemetry("second")))
+
+	if pending := storage.pending(); len(pending) != 2 || pending[0] != first || pending[1] != second {
+		t.Errorf("Unexpected pending files: %q", pending)
+	}
+
+	// Over the size limit: the oldest file gets dropped.
+	fakeClock.Increment(time.Minute)
+	third := storage.save(payload)
+	if pending := storage.pending(); len(pending) != 1 || pending[0] != second {
+		t.Errorf("Unexpected pending files: %q", pending)
+	}
+
+	if storage.claim(third) {
+		t.Error("Claimed a file that is in-flight")
+	}
+
+	// Past the age limit: everything that isn't in-flight is dropped.
+	fakeClock.Increment(2 * time.Hour)
+	if pending := storage.pending(); len(pending) != 0 {
+		t.Errorf("Unexpected pending files: %q", pending)
+	}
+
+	waitForStoredFiles(t, directory, 1)
+}
+
+func TestDiskStorageReleasesUnreadableFile(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	directory := newTestStorageDirectory(t)
+	defer os.RemoveAll(directory)
+
+	storage, err := newDiskStorage(directory, 1000, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %s", err.Error())
+	}
+
+	payload := []byte("0123456789")
+	name := storage.save(payload)
+	storage.settle(name, 1, payload, telemetryBuffer(NewEventTelemetry("event")))
+	if !storage.claim(name) {
+		t.Fatal("Failed to claim stored file")
+	}
+
+	// Make the file unreadable by replacing it with a directory.
+	path := filepath.Join(directory, name)
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove stored file: %s", err.Error())
+	}
+
+	if err := os.Mkdir(path, 0700); err != nil {
+		t.Fatalf("Failed to create directory: %s", err.Error())
+	}
+
+	if _, _, err := storage.load(name); err == nil {
+		t.Fatal("Loaded a file that cannot be read")
+	}
+
+	if !storage.claim(name) {
+		t.Error("File is still in-flight after failing to load")
+	}
+}
+
+func TestStorageFallsBackToMemory(t *testing.T) {
+	directory := newTestStorageDirectory(t)
+	defer os.RemoveAll(directory)
+
+	// A regular file cannot be used as the storage directory.
+	file := filepath.Join(directory, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("Failed to create file: %s", err.Error())
+	}
+
+	config := NewTelemetryConfiguration(test_ikey)
+	config.StorageDirectory = file
+	client := NewTelemetryClientFromConfig(config)
+	defer client.Channel().Stop()
+
+	if _, ok := client.Channel().(*InMemoryChannel); !ok {
+		t.Error("Client did not fall back to InMemoryChannel")
+	}
+}
+
+func TestStorageFallsBackFromReadOnlyDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("Directory permissions are not enforced")
+	}
+
+	directory := newTestStorageDirectory(t)
+	defer os.RemoveAll(directory)
+
+	if err := os.Chmod(directory, 0500); err != nil {
+		t.Fatalf("Failed to make directory read-only: %s", err.Error())
+	}
+	defer os.Chmod(directory, 0700)
+
+	if _, err := newDiskStorage(directory, 0, 0); err == nil {
+		t.Error("Read-only directory was accepted")
+	}
+
+	config := NewTelemetryConfiguration(test_ikey)
+	config.StorageDirectory = directory
+	client := NewTelemetryClientFromConfig(config)
+	defer client.Channel().Stop()
+
+	if _, ok := client.Channel().(*InMemoryChannel); !ok {
+		t.Error("Client did not fall back to InMemoryChannel")
+	}
+}
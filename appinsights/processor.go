@@ -0,0 +1,35 @@
+package appinsights
+
+import "github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+
+// Telemetry processors inspect each telemetry envelope after it has been
+// built by the client, and before it is handed to the channel.  They can be
+// used to filter out unwanted telemetry, enrich it, or replace it entirely.
+type TelemetryProcessor interface {
+	// Processes the specified envelope.  Returns the envelope to pass
+	// on to the next processor, which may be the same envelope
+	// (modified or not) or a replacement.  Returns nil to discard the
+	// telemetry; no further processors will see it.
+	Process(envelope *contracts.Envelope) *contracts.Envelope
+}
+
+// Adapter that allows an ordinary function to be used as a
+// TelemetryProcessor.
+type TelemetryProcessorFunc func(envelope *contracts.Envelope) *contracts.Envelope
+
+// Calls the function with the specified envelope.
+func (f TelemetryProcessorFunc) Process(envelope *contracts.Envelope) *contracts.Envelope {
+	return f(envelope)
+}
+
+// Runs the envelope through each processor in order.  Returns nil if any of
+// them discarded it.
+func processTelemetry(processors []TelemetryProcessor, envelope *contracts.Envelope) *contracts.Envelope {
+	for _, processor := range processors {
+		if envelope = processor.Process(envelope); envelope == nil {
+			return nil
+		}
+	}
+
+	return envelope
+}
@@ -0,0 +1,110 @@
+package appinsights
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func newTestProcessorClient(processors ...TelemetryProcessor) (TelemetryClient, *testTransmitter) {
+	config := NewTelemetryConfiguration(test_ikey)
+	config.MaxBatchInterval = ten_seconds
+	config.TelemetryProcessors = processors
+	return newTestChannelServer(config)
+}
+
+func TestProcessorDrop(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	// Filter out health checks
+	client, transmitter := newTestProcessorClient(TelemetryProcessorFunc(func(envelope *contracts.Envelope) *contracts.Envelope {
+		if request, ok := envelope.Data.(*contracts.Data).BaseData.(*contracts.RequestData); ok && strings.HasSuffix(request.Url, "/health") {
+			return nil
+		}
+
+		return envelope
+	}))
+	defer transmitter.Close()
+
+	transmitter.prepResponse(200)
+
+	client.TrackRequest("GET", "http://localhost/health", time.Millisecond, "200")
+	client.TrackRequest("GET", "http://localhost/api", time.Millisecond, "200")
+	waitForClose(t, client.Channel().Close())
+
+	req := transmitter.waitForRequest(t)
+	if len(req.items) != 1 || strings.Contains(req.payload, "/health") || !strings.Contains(req.payload, "/api") {
+		t.Error("Unexpected payload")
+	}
+}
+
+func TestProcessorOrderAndMutate(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	appendProperty := func(value string) TelemetryProcessor {
+		return TelemetryProcessorFunc(func(envelope *contracts.Envelope) *contracts.Envelope {
+			data := envelope.Data.(*contracts.Data).BaseData.(*contracts.MessageData)
+			data.Properties["order"] += value
+			envelope.Tags[contracts.CloudRole] = value
+			return envelope
+		})
+	}
+
+	client, transmitter := newTestProcessorClient(appendProperty("a"), appendProperty("b"), appendProperty("c"))
+	defer transmitter.Close()
+
+	transmitter.prepResponse(200)
+
+	client.TrackTrace("~msg~", Information)
+	waitForClose(t, client.Channel().Close())
+
+	req := transmitter.waitForRequest(t)
+	if len(req.items) != 1 {
+		t.Fatal("Unexpected payload")
+	}
+
+	data := req.items[0].Data.(*contracts.Data).BaseData.(*contracts.MessageData)
+	if data.Properties["order"] != "abc" {
+		t.Errorf("Processors ran out of order: %s", data.Properties["order"])
+	}
+
+	if role := req.items[0].Tags[contracts.CloudRole]; role != "c" {
+		t.Errorf("Cloud role is %s, want c", role)
+	}
+}
+
+func TestProcessorReplace(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	var seen []string
+	client, transmitter := newTestProcessorClient(
+		TelemetryProcessorFunc(func(envelope *contracts.Envelope) *contracts.Envelope {
+			return NewTelemetryContext(test_ikey).envelop(NewEventTelemetry("~replacement~"))
+		}),
+		TelemetryProcessorFunc(func(envelope *contracts.Envelope) *contracts.Envelope {
+			seen = append(seen, envelope.Name)
+			return envelope
+		}),
+		TelemetryProcessorFunc(func(envelope *contracts.Envelope) *contracts.Envelope {
+			return nil
+		}),
+		TelemetryProcessorFunc(func(envelope *contracts.Envelope) *contracts.Envelope {
+			t.Error("Processor after a drop should not run")
+			return envelope
+		}),
+	)
+	defer transmitter.Close()
+
+	client.TrackTrace("~msg~", Information)
+	waitForClose(t, client.Channel().Close())
+	transmitter.assertNoRequest(t)
+
+	if len(seen) != 1 || !strings.HasSuffix(seen[0], ".Event") {
+		t.Errorf("Replacement was not passed on: %q", seen)
+	}
+}
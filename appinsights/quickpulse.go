@@ -0,0 +1,388 @@
+package appinsights
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Paths and headers of the Live Metrics (QuickPulse) service protocol.
+const (
+	quickPulsePath          = "/QuickPulseService.svc"
+	quickPulseSubscribed    = "x-ms-qps-subscribed"
+	quickPulsePollingHint   = "x-ms-qps-service-polling-interval-hint"
+	quickPulseRedirect      = "x-ms-qps-service-endpoint-redirect-v2"
+	quickPulseTransmitTime  = "x-ms-qps-transmission-time"
+	quickPulseMachineName   = "x-ms-qps-machine-name"
+	quickPulseInstanceName  = "x-ms-qps-instance-name"
+	quickPulseRoleName      = "x-ms-qps-role-name"
+	quickPulseStreamId      = "x-ms-qps-stream-id"
+	quickPulseInvariantVers = "x-ms-qps-invariant-version"
+	quickPulseInvariant     = 1
+)
+
+// Intervals at which the service is contacted.
+var (
+	// Between pings while nobody is watching.
+	quickPulsePingInterval = time.Duration(5) * time.Second
+
+	// Between pings once they have been failing for
+	// quickPulsePingBackoffAfter.
+	quickPulsePingBackoffInterval = time.Duration(60) * time.Second
+	quickPulsePingBackoffAfter    = time.Duration(60) * time.Second
+
+	// Between posts while somebody is watching.  This is also the length
+	// of each window over which telemetry is aggregated.
+	quickPulsePostInterval = time.Second
+
+	// Posts failing for this long go back to pinging.
+	quickPulsePostTimeout = time.Duration(20) * time.Second
+)
+
+// Names of the metrics shown in the Live Metrics view.
+const (
+	quickPulseRequestRate             = `\ApplicationInsights\Requests/Sec`
+	quickPulseRequestDuration         = `\ApplicationInsights\Request Duration`
+	quickPulseRequestFailedRate       = `\ApplicationInsights\Requests Failed/Sec`
+	quickPulseRequestSucceededRate    = `\ApplicationInsights\Requests Succeeded/Sec`
+	quickPulseDependencyRate          = `\ApplicationInsights\Dependency Calls/Sec`
+	quickPulseDependencyDuration      = `\ApplicationInsights\Dependency Call Duration`
+	quickPulseDependencyFailedRate    = `\ApplicationInsights\Dependency Calls Failed/Sec`
+	quickPulseDependencySucceededRate = `\ApplicationInsights\Dependency Calls Succeeded/Sec`
+	quickPulseExceptionRate           = `\ApplicationInsights\Exceptions/Sec`
+	quickPulseMemory                  = `\Memory\Committed Bytes`
+	quickPulseCpu                     = `\Processor(_Total)\% Processor Time`
+)
+
+// Offset of the Unix epoch in .NET ticks (100ns since 0001-01-01), which
+// the service uses for transmission times.
+const dotnetEpochTicks = 621355968000000000
+
+// Streams Live Metrics: while the service reports that somebody is viewing
+// this application in the portal, telemetry tracked by the client is
+// aggregated into one-second windows and posted to the service.
+// Otherwise, the service is only pinged periodically.
+type quickPulse struct {
+	endpoint     string
+	ikey         string
+	client       *http.Client
+	streamId     string
+	machineName  string
+	instanceName string
+	roleName     string
+
+	collecting int32 // accessed atomically
+	lock       sync.Mutex
+	window     quickPulseWindow
+
+	subscribed      bool
+	lastPingSuccess time.Time
+	lastPostSuccess time.Time
+	pollingInterval time.Duration
+	lastCpu         processCpuSample
+	ctx             context.Context
+	cancel          context.CancelFunc
+	stopOnce        sync.Once
+	done            chan struct{}
+}
+
+// Telemetry counted over one window.
+type quickPulseWindow struct {
+	start              time.Time
+	requests           int
+	requestsFailed     int
+	requestDuration    time.Duration
+	dependencies       int
+	dependenciesFailed int
+	dependencyDuration time.Duration
+	exceptions         int
+}
+
+// Structures sent to the service
+type quickPulseDataPoint struct {
+	Version                        string
+	InvariantVersion               int
+	Instance                       string
+	RoleName                       string
+	MachineName                    string
+	StreamId                       string
+	Timestamp                      string
+	IsWebApp                       bool
+	PerformanceCollectionSupported bool
+	Metrics                        []*quickPulseMetric
+	Documents                      []interface{}
+	TopCpuProcesses                []interface{}
+	CollectionConfigurationErrors  []interface{}
+}
+
+type quickPulseMetric struct {
+	Name   string
+	Value  float64
+	Weight int
+}
+
+// Creates a quickPulse for the configuration and context of a new client,
+// and starts pinging the service in a background goroutine.
+func newQuickPulse(config *TelemetryConfiguration, telemetryContext *TelemetryContext) *quickPulse {
+	client := config.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	machineName, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	qp := &quickPulse{
+		endpoint:        strings.TrimSuffix(config.LiveEndpointUrl, "/"),
+		ikey:            config.InstrumentationKey,
+		client:          client,
+		streamId:        strings.Replace(newUUID().String(), "-", "", -1),
+		machineName:     machineName,
+		instanceName:    telemetryContext.Tags.Cloud().GetRoleInstance(),
+		roleName:        telemetryContext.Tags.Cloud().GetRole(),
+		lastPingSuccess: currentClock.Now(),
+		pollingInterval: quickPulsePingInterval,
+		ctx:             ctx,
+		cancel:          cancel,
+		done:            make(chan struct{}),
+	}
+
+	go qp.run()
+
+	return qp
+}
+
+// Counts a telemetry item in the current window, if somebody is watching.
+func (qp *quickPulse) observe(item Telemetry) {
+	if atomic.LoadInt32(&qp.collecting) == 0 {
+		return
+	}
+
+	qp.lock.Lock()
+	defer qp.lock.Unlock()
+
+	switch telem := item.(type) {
+	case *RequestTelemetry:
+		qp.window.requests++
+		qp.window.requestDuration += telem.Duration
+		if !telem.Success {
+			qp.window.requestsFailed++
+		}
+	case *RemoteDependencyTelemetry:
+		qp.window.dependencies++
+		qp.window.dependencyDuration += telem.Duration
+		if !telem.Success {
+			qp.window.dependenciesFailed++
+		}
+	case *ExceptionTelemetry:
+		qp.window.exceptions++
+	}
+}
+
+// Stops contacting the service, and cancels any request in progress.
+func (qp *quickPulse) stop() {
+	qp.stopOnce.Do(func() {
+		qp.cancel()
+		<-qp.done
+	})
+}
+
+func (qp *quickPulse) run() {
+	defer close(qp.done)
+
+	timer := currentClock.NewTimer(qp.step())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-qp.ctx.Done():
+			return
+		case <-timer.C():
+			timer.Reset(qp.step())
+		}
+	}
+}
+
+// Pings or posts, depending on whether somebody is watching.  Returns how
+// long to wait before the next step.
+func (qp *quickPulse) step() time.Duration {
+	now := currentClock.Now()
+
+	if !qp.subscribed {
+		subscribed, ok := qp.send("ping", qp.newDataPoint(now, nil))
+		if !ok {
+			if now.Sub(qp.lastPingSuccess) >= quickPulsePingBackoffAfter {
+				return quickPulsePingBackoffInterval
+			}
+
+			return quickPulsePingInterval
+		}
+
+		qp.lastPingSuccess = now
+		if !subscribed {
+			return qp.pollingInterval
+		}
+
+		diagnosticsWriter.Write("Live Metrics: a viewer is attached; starting to post")
+		qp.subscribed = true
+		qp.lastPostSuccess = now
+		qp.lastCpu = readProcessCpu()
+		qp.lock.Lock()
+		qp.window = quickPulseWindow{start: now}
+		qp.lock.Unlock()
+		atomic.StoreInt32(&qp.collecting, 1)
+		return quickPulsePostInterval
+	}
+
+	subscribed, ok := qp.send("post", []*quickPulseDataPoint{qp.newDataPoint(now, qp.collect(now))})
+	if ok {
+		qp.lastPostSuccess = now
+	}
+
+	if (ok && !subscribed) || (!ok && now.Sub(qp.lastPostSuccess) >= quickPulsePostTimeout) {
+		diagnosticsWriter.Write("Live Metrics: no viewer is attached; going back to pinging")
+		atomic.StoreInt32(&qp.collecting, 0)
+		qp.subscribed = false
+		qp.lastPingSuccess = now
+		return qp.pollingInterval
+	}
+
+	return quickPulsePostInterval
+}
+
+// Takes the current window's counts, and converts them to metrics.
+func (qp *quickPulse) collect(now time.Time) []*quickPulseMetric {
+	qp.lock.Lock()
+	window := qp.window
+	qp.window = quickPulseWindow{start: now}
+	qp.lock.Unlock()
+
+	seconds := now.Sub(window.start).Seconds()
+	if seconds <= 0.0 {
+		seconds = quickPulsePostInterval.Seconds()
+	}
+
+	average := func(total time.Duration, count int) float64 {
+		if count == 0 {
+			return 0.0
+		}
+
+		return float64(total) / float64(time.Millisecond) / float64(count)
+	}
+
+	metrics := []*quickPulseMetric{
+		{quickPulseRequestRate, float64(window.requests) / seconds, 1},
+		{quickPulseRequestDuration, average(window.requestDuration, window.requests), 1},
+		{quickPulseRequestFailedRate, float64(window.requestsFailed) / seconds, 1},
+		{quickPulseRequestSucceededRate, float64(window.requests-window.requestsFailed) / seconds, 1},
+		{quickPulseDependencyRate, float64(window.dependencies) / seconds, 1},
+		{quickPulseDependencyDuration, average(window.dependencyDuration, window.dependencies), 1},
+		{quickPulseDependencyFailedRate, float64(window.dependenciesFailed) / seconds, 1},
+		{quickPulseDependencySucceededRate, float64(window.dependencies-window.dependenciesFailed) / seconds, 1},
+		{quickPulseExceptionRate, float64(window.exceptions) / seconds, 1},
+	}
+
+	if rss, ok := readProcessResidentBytes(); ok {
+		metrics = append(metrics, &quickPulseMetric{quickPulseMemory, float64(rss), 1})
+	} else {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		metrics = append(metrics, &quickPulseMetric{quickPulseMemory, float64(stats.Sys), 1})
+	}
+
+	cpu := readProcessCpu()
+	if cpu.valid && qp.lastCpu.valid {
+		if elapsed := cpu.timestamp.Sub(qp.lastCpu.timestamp); elapsed > 0 {
+			used := cpu.cpuTime - qp.lastCpu.cpuTime
+			percent := 100.0 * float64(used) / float64(elapsed) / float64(runtime.NumCPU())
+			metrics = append(metrics, &quickPulseMetric{quickPulseCpu, percent, 1})
+		}
+	}
+
+	qp.lastCpu = cpu
+	return metrics
+}
+
+func (qp *quickPulse) newDataPoint(now time.Time, metrics []*quickPulseMetric) *quickPulseDataPoint {
+	return &quickPulseDataPoint{
+		Version:                        sdkName + ":" + Version,
+		InvariantVersion:               quickPulseInvariant,
+		Instance:                       qp.instanceName,
+		RoleName:                       qp.roleName,
+		MachineName:                    qp.machineName,
+		StreamId:                       qp.streamId,
+		Timestamp:                      "/Date(" + strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10) + ")/",
+		PerformanceCollectionSupported: true,
+		Metrics:                        metrics,
+		Documents:                      []interface{}{},
+		TopCpuProcesses:                []interface{}{},
+		CollectionConfigurationErrors:  []interface{}{},
+	}
+}
+
+// Sends a ping or post to the service.  Returns whether the service says
+// somebody is watching, and whether the request succeeded.  Honors the
+// polling interval and endpoint redirect headers in the response.
+func (qp *quickPulse) send(method string, body interface{}) (subscribed, ok bool) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		diagnosticsWriter.Printf("Live Metrics: failed to serialize %s: %s", method, err.Error())
+		return false, false
+	}
+
+	address := qp.endpoint + quickPulsePath + "/" + method + "?ikey=" + url.QueryEscape(qp.ikey)
+	req, err := http.NewRequest("POST", address, bytes.NewReader(payload))
+	if err != nil {
+		diagnosticsWriter.Printf("Live Metrics: failed to create %s: %s", method, err.Error())
+		return false, false
+	}
+
+	ticks := currentClock.Now().UnixNano()/100 + dotnetEpochTicks
+	req = req.WithContext(withInternalRequest(qp.ctx))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(quickPulseTransmitTime, strconv.FormatInt(ticks, 10))
+	req.Header.Set(quickPulseMachineName, qp.machineName)
+	req.Header.Set(quickPulseInstanceName, qp.instanceName)
+	req.Header.Set(quickPulseRoleName, qp.roleName)
+	req.Header.Set(quickPulseStreamId, qp.streamId)
+	req.Header.Set(quickPulseInvariantVers, strconv.Itoa(quickPulseInvariant))
+
+	resp, err := qp.client.Do(req)
+	if err != nil {
+		diagnosticsWriter.Printf("Live Metrics: %s failed: %s", method, err.Error())
+		return false, false
+	}
+
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		diagnosticsWriter.Printf("Live Metrics: %s failed with status %d", method, resp.StatusCode)
+		return false, false
+	}
+
+	if hint := resp.Header.Get(quickPulsePollingHint); hint != "" {
+		if ms, err := strconv.ParseInt(hint, 10, 64); err == nil && ms > 0 {
+			qp.pollingInterval = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	if redirect := resp.Header.Get(quickPulseRedirect); redirect != "" {
+		if u, err := url.Parse(redirect); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			qp.endpoint = strings.TrimSuffix(redirect, "/")
+		}
+	}
+
+	return strings.EqualFold(resp.Header.Get(quickPulseSubscribed), "true"), true
+}
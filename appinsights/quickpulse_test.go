@@ -0,0 +1,243 @@
+package appinsights
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testQuickPulseServer struct {
+	server     *httptest.Server
+	requests   chan *testQuickPulseRequest
+	subscribed int32
+	hint       string
+}
+
+type testQuickPulseRequest struct {
+	method string
+	query  string
+	header http.Header
+	body   []byte
+}
+
+func newTestQuickPulseServer(subscribed bool, hint string) *testQuickPulseServer {
+	qps := &testQuickPulseServer{
+		requests: make(chan *testQuickPulseRequest, 64),
+		hint:     hint,
+	}
+
+	qps.setSubscribed(subscribed)
+	qps.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		qps.requests <- &testQuickPulseRequest{
+			method: strings.TrimPrefix(r.URL.Path, quickPulsePath+"/"),
+			query:  r.URL.RawQuery,
+			header: r.Header,
+			body:   body,
+		}
+
+		w.Header().Set(quickPulseSubscribed, strconv.FormatBool(atomic.LoadInt32(&qps.subscribed) != 0))
+		if qps.hint != "" {
+			w.Header().Set(quickPulsePollingHint, qps.hint)
+		}
+	}))
+
+	return qps
+}
+
+func (qps *testQuickPulseServer) setSubscribed(subscribed bool) {
+	if subscribed {
+		atomic.StoreInt32(&qps.subscribed, 1)
+	} else {
+		atomic.StoreInt32(&qps.subscribed, 0)
+	}
+}
+
+// Advances the clock until the next request arrives, and checks that it is
+// of the expected method.  Each tick allows plenty of time for a request in
+// progress to finish, so that the next one isn't started early.
+func (qps *testQuickPulseServer) waitForRequest(t *testing.T, method string) *testQuickPulseRequest {
+	for i := 0; i < 100; i++ {
+		select {
+		case req := <-qps.requests:
+			if req.method != method {
+				t.Fatalf("Got %s, want %s", req.method, method)
+			}
+
+			return req
+		case <-time.After(time.Duration(50) * time.Millisecond):
+			slowTick(1)
+		}
+	}
+
+	t.Fatalf("Timed out waiting for %s", method)
+	return nil /* Not reached */
+}
+
+func (req *testQuickPulseRequest) transmissionTime(t *testing.T) time.Time {
+	ticks, err := strconv.ParseInt(req.header.Get(quickPulseTransmitTime), 10, 64)
+	if err != nil {
+		t.Fatalf("Bad transmission time: %s", err.Error())
+	}
+
+	return time.Unix(0, (ticks-dotnetEpochTicks)*100)
+}
+
+func newTestLiveMetricsClient(qps *testQuickPulseServer) (TelemetryClient, *testTransmitter) {
+	config := NewTelemetryConfiguration(test_ikey)
+	config.LiveEndpointUrl = qps.server.URL
+	config.LiveMetrics = true
+	return newTestChannelServer(config)
+}
+
+func TestQuickPulsePing(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	qps := newTestQuickPulseServer(false, "2000")
+	defer qps.server.Close()
+	client, transmitter := newTestLiveMetricsClient(qps)
+	defer transmitter.Close()
+
+	ping := qps.waitForRequest(t, "ping")
+	checkDataContract(t, "query", ping.query, "ikey="+test_ikey)
+	checkDataContract(t, quickPulseInvariantVers, ping.header.Get(quickPulseInvariantVers), "1")
+	checkNotNullOrEmpty(t, quickPulseMachineName, ping.header.Get(quickPulseMachineName))
+
+	var dataPoint quickPulseDataPoint
+	if err := json.Unmarshal(ping.body, &dataPoint); err != nil {
+		t.Fatalf("Bad ping: %s", err.Error())
+	}
+
+	checkNotNullOrEmpty(t, "StreamId", dataPoint.StreamId)
+	checkDataContract(t, "StreamId", ping.header.Get(quickPulseStreamId), dataPoint.StreamId)
+	checkDataContract(t, "Version", dataPoint.Version, sdkName+":"+Version)
+	if len(dataPoint.Metrics) != 0 {
+		t.Error("Ping carried metrics")
+	}
+
+	// Nothing is collected while nobody is watching.
+	client.TrackRequest("GET", "http://localhost/", time.Second, "200")
+	qp := client.(*telemetryClient).liveMetrics
+	qp.lock.Lock()
+	n := qp.window.requests
+	qp.lock.Unlock()
+	if n != 0 {
+		t.Errorf("Counted %d requests while not subscribed", n)
+	}
+
+	// The polling interval comes from the service.
+	next := qps.waitForRequest(t, "ping")
+	checkDataContract(t, "Ping interval", next.transmissionTime(t).Sub(ping.transmissionTime(t)), 2*time.Second)
+
+	transmitter.prepResponse(200)
+	waitForClose(t, client.Channel().Close())
+	select {
+	case <-qp.done:
+	default:
+		t.Error("Live metrics were not stopped with the channel")
+	}
+}
+
+func TestQuickPulsePost(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	qps := newTestQuickPulseServer(true, "")
+	defer qps.server.Close()
+	client, transmitter := newTestLiveMetricsClient(qps)
+	defer transmitter.Close()
+	defer client.Channel().Stop()
+
+	qps.waitForRequest(t, "ping")
+	qps.waitForRequest(t, "post")
+
+	client.TrackRequest("GET", "http://localhost/", 100*time.Millisecond, "200")
+	client.TrackRequest("GET", "http://localhost/", 300*time.Millisecond, "500")
+	dependency := NewRemoteDependencyTelemetry("SELECT", "SQL", "db", false)
+	dependency.Duration = 50 * time.Millisecond
+	client.Track(dependency)
+	client.TrackException("~error~")
+
+	post := qps.waitForRequest(t, "post")
+	var dataPoints []*quickPulseDataPoint
+	if err := json.Unmarshal(post.body, &dataPoints); err != nil || len(dataPoints) != 1 {
+		t.Fatalf("Bad post: %s", string(post.body))
+	}
+
+	metrics := make(map[string]float64)
+	for _, metric := range dataPoints[0].Metrics {
+		metrics[metric.Name] = metric.Value
+	}
+
+	checkDataContract(t, quickPulseRequestRate, metrics[quickPulseRequestRate], 2.0)
+	checkDataContract(t, quickPulseRequestDuration, metrics[quickPulseRequestDuration], 200.0)
+	checkDataContract(t, quickPulseRequestFailedRate, metrics[quickPulseRequestFailedRate], 1.0)
+	checkDataContract(t, quickPulseRequestSucceededRate, metrics[quickPulseRequestSucceededRate], 1.0)
+	checkDataContract(t, quickPulseDependencyRate, metrics[quickPulseDependencyRate], 1.0)
+	checkDataContract(t, quickPulseDependencyDuration, metrics[quickPulseDependencyDuration], 50.0)
+	checkDataContract(t, quickPulseDependencyFailedRate, metrics[quickPulseDependencyFailedRate], 1.0)
+	checkDataContract(t, quickPulseDependencySucceededRate, metrics[quickPulseDependencySucceededRate], 0.0)
+	checkDataContract(t, quickPulseExceptionRate, metrics[quickPulseExceptionRate], 1.0)
+	if metrics[quickPulseMemory] <= 0.0 {
+		t.Error("Memory was not reported")
+	}
+
+	// Once the viewer goes away, go back to pinging.
+	qps.setSubscribed(false)
+	last := qps.waitForRequest(t, "post")
+	ping := qps.waitForRequest(t, "ping")
+	checkDataContract(t, "Ping interval", ping.transmissionTime(t).Sub(last.transmissionTime(t)), quickPulsePingInterval)
+}
+
+func TestQuickPulseFailures(t *testing.T) {
+	mockClock()
+	defer resetClock()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(503)
+	}))
+	defer server.Close()
+
+	config := NewTelemetryConfiguration(test_ikey)
+	config.LiveEndpointUrl = server.URL
+	qp := newQuickPulse(config, config.setupContext())
+	defer qp.stop()
+
+	// Pings every 5 seconds for a minute, then backs off to once a minute.
+	for i := 0; i < 120; i++ {
+		slowTick(1)
+	}
+
+	if n := atomic.LoadInt32(&requests); n < 13 || n > 15 {
+		t.Errorf("Sent %d pings in two minutes, want 14", n)
+	}
+}
+
+func TestQuickPulseDisabledClient(t *testing.T) {
+	qps := newTestQuickPulseServer(true, "")
+	defer qps.server.Close()
+
+	config := NewTelemetryConfiguration(test_ikey)
+	config.LiveEndpointUrl = qps.server.URL
+	config.LiveMetrics = true
+	config.Disabled = true
+	client := NewTelemetryClientFromConfig(config)
+	defer client.Channel().Stop()
+
+	if client.(*telemetryClient).liveMetrics != nil {
+		t.Error("Live metrics were started for a disabled client")
+	}
+
+	select {
+	case req := <-qps.requests:
+		t.Errorf("Disabled client sent %s", req.method)
+	case <-time.After(time.Duration(50) * time.Millisecond):
+	}
+}
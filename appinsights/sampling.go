@@ -0,0 +1,138 @@
+package appinsights
+
+import (
+	"math"
+	"unicode/utf16"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Set of telemetry types, used to select which telemetry is subject to
+// sampling.
+type TelemetryTypes uint
+
+const (
+	EventTelemetryType TelemetryTypes = 1 << iota
+	TraceTelemetryType
+	MetricTelemetryType
+	AggregateMetricTelemetryType
+	RequestTelemetryType
+	RemoteDependencyTelemetryType
+	ExceptionTelemetryType
+	AvailabilityTelemetryType
+	PageViewTelemetryType
+
+	// All telemetry types
+	AllTelemetryTypes TelemetryTypes = (PageViewTelemetryType << 1) - 1
+
+	// Telemetry types that are sampled unless otherwise specified.
+	// Metrics are excluded since sampling them would skew their values.
+	DefaultSampledTelemetryTypes TelemetryTypes = AllTelemetryTypes &^ (MetricTelemetryType | AggregateMetricTelemetryType)
+)
+
+// A telemetry processor that keeps a fixed percentage of telemetry.  The
+// decision is made from the item's operation ID, so all telemetry belonging
+// to an operation is either kept or discarded together, consistently with
+// other Application Insights SDKs.  Kept items have their envelope's
+// SampleRate set so that the portal can account for what was discarded.
+type FixedRateSampler struct {
+	// Percentage of telemetry to keep, from 0 to 100.
+	Percentage float64
+
+	// Telemetry types that are subject to sampling.  Other types are
+	// always kept.
+	Types TelemetryTypes
+}
+
+// Creates a sampler that keeps the specified percentage of telemetry of
+// the DefaultSampledTelemetryTypes.
+func NewFixedRateSampler(percentage float64) *FixedRateSampler {
+	return &FixedRateSampler{
+		Percentage: percentage,
+		Types:      DefaultSampledTelemetryTypes,
+	}
+}
+
+// Returns the envelope if it is sampled in, or nil otherwise.
+func (sampler *FixedRateSampler) Process(envelope *contracts.Envelope) *contracts.Envelope {
+	return sampleEnvelope(envelope, sampler.Percentage, sampler.Types)
+}
+
+// Applies a sampling decision at the specified percentage to an envelope,
+// if it is one of the specified types.
+func sampleEnvelope(envelope *contracts.Envelope, percentage float64, types TelemetryTypes) *contracts.Envelope {
+	if percentage >= 100.0 || telemetryTypeOf(envelope)&types == 0 {
+		return envelope
+	}
+
+	if samplingScore(envelope.Tags[contracts.OperationId]) >= percentage {
+		return nil
+	}
+
+	envelope.SampleRate = percentage
+	return envelope
+}
+
+// Determines the type of telemetry contained in an envelope.
+func telemetryTypeOf(envelope *contracts.Envelope) TelemetryTypes {
+	data, ok := envelope.Data.(*contracts.Data)
+	if !ok {
+		return 0
+	}
+
+	switch baseData := data.BaseData.(type) {
+	case *contracts.EventData:
+		return EventTelemetryType
+	case *contracts.MessageData:
+		return TraceTelemetryType
+	case *contracts.MetricData:
+		for _, dataPoint := range baseData.Metrics {
+			if dataPoint.Kind == contracts.Aggregation {
+				return AggregateMetricTelemetryType
+			}
+		}
+
+		return MetricTelemetryType
+	case *contracts.RequestData:
+		return RequestTelemetryType
+	case *contracts.RemoteDependencyData:
+		return RemoteDependencyTelemetryType
+	case *contracts.ExceptionData:
+		return ExceptionTelemetryType
+	case *contracts.AvailabilityData:
+		return AvailabilityTelemetryType
+	case *contracts.PageViewData:
+		return PageViewTelemetryType
+	default:
+		return 0
+	}
+}
+
+// Computes a score in the range [0, 100) from an operation ID.  Telemetry is
+// kept if its score is below the sampling percentage.  This matches the
+// algorithm used by the other Application Insights SDKs, so that services
+// sampling at the same rate keep the same operations.
+func samplingScore(operationId string) float64 {
+	if operationId == "" {
+		return 0.0
+	}
+
+	// The other SDKs hash UTF-16 code units.
+	units := utf16.Encode([]rune(operationId))
+	for len(units) < 8 {
+		units = append(units, units...)
+	}
+
+	var hash int32 = 5381
+	for _, c := range units {
+		hash = (hash << 5) + hash + int32(c)
+	}
+
+	if hash == math.MinInt32 {
+		hash = math.MaxInt32
+	} else if hash < 0 {
+		hash = -hash
+	}
+
+	return float64(hash) / float64(math.MaxInt32) * 100.0
+}
@@ -0,0 +1,131 @@
+package appinsights
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func envelopWithOperation(item Telemetry, operationId string) *contracts.Envelope {
+	item.ContextTags()[contracts.OperationId] = operationId
+	return NewTelemetryContext(test_ikey).envelop(item)
+}
+
+func TestSamplingScore(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		id := newUUID().String()
+		score := samplingScore(id)
+		if score < 0.0 || score >= 100.0 {
+			t.Errorf("Score for %s out of range: %f", id, score)
+		}
+
+		if samplingScore(id) != score {
+			t.Errorf("Score for %s is not deterministic", id)
+		}
+	}
+
+	if score := samplingScore(""); score != 0.0 {
+		t.Errorf("Score for empty operation ID is %f, want 0", score)
+	}
+}
+
+func TestSamplingScoreMatchesDotNet(t *testing.T) {
+	// Scores computed with the hash from SamplingScoreGenerator in the
+	// .NET SDK: djb2 over the UTF-16 code units of the ID, repeated to at
+	// least 8 characters, with unchecked 32-bit arithmetic.  Services
+	// using either SDK must agree on these for a trace to be sampled
+	// consistently.
+	vectors := []struct {
+		operationId string
+		score       float64
+	}{
+		{"a", 16.249091046046974},
+		{"abc", 46.12368808413096},
+		{"12345678", 43.69800283745769},
+		{"0af7651916cd43dd8448eb211c80319c", 52.78892803601405},
+		{"4bf92f3577b34da6a3ce929d0e0e4736", 33.46135385030012},
+		{"|a1b2c3d4.e5f6a7b8.", 94.28285034107176},
+		{"Test operation", 7.140333488183251},
+		{"café", 2.0266046291341095},
+		{"操作", 47.6754029037782},
+	}
+
+	for _, vector := range vectors {
+		if score := samplingScore(vector.operationId); math.Abs(score-vector.score) > 1e-9 {
+			t.Errorf("Score for %q is %v, want %v", vector.operationId, score, vector.score)
+		}
+	}
+}
+
+func TestFixedRateSampler(t *testing.T) {
+	sampler := NewFixedRateSampler(25.0)
+
+	kept := 0
+	for i := 0; i < 4000; i++ {
+		operationId := newUUID().String()
+		request := sampler.Process(envelopWithOperation(NewRequestTelemetry("GET", "http://localhost/", time.Second, "200"), operationId))
+		trace := sampler.Process(envelopWithOperation(NewTraceTelemetry("~msg~", Information), operationId))
+
+		if (request == nil) != (trace == nil) {
+			t.Fatal("Items from the same operation were sampled differently")
+		}
+
+		if request != nil {
+			kept++
+			if request.SampleRate != 25.0 || trace.SampleRate != 25.0 {
+				t.Errorf("SampleRate is %f, want 25", request.SampleRate)
+			}
+		}
+	}
+
+	if kept < 800 || kept > 1200 {
+		t.Errorf("Kept %d of 4000 operations at 25%%", kept)
+	}
+}
+
+func TestFixedRateSamplerTypes(t *testing.T) {
+	sampler := NewFixedRateSampler(0.0)
+	sampler.Types &^= ExceptionTelemetryType
+
+	aggregate := NewAggregateMetricTelemetry("~agg~")
+	aggregate.AddData([]float64{1, 2, 3})
+
+	kept := []Telemetry{
+		NewExceptionTelemetry("~error~"),
+		NewMetricTelemetry("~metric~", 1.0),
+		aggregate,
+	}
+
+	dropped := []Telemetry{
+		NewEventTelemetry("~event~"),
+		NewTraceTelemetry("~msg~", Information),
+		NewRequestTelemetry("GET", "http://localhost/", time.Second, "200"),
+		NewRemoteDependencyTelemetry("~dep~", "HTTP", "localhost", true),
+		NewAvailabilityTelemetry("~test~", time.Second, true),
+		NewPageViewTelemetry("~page~", "http://localhost/"),
+	}
+
+	for _, item := range kept {
+		envelope := sampler.Process(envelopWithOperation(item, newUUID().String()))
+		if envelope == nil {
+			t.Errorf("%T should not have been sampled", item)
+		} else if envelope.SampleRate != 100.0 {
+			t.Errorf("%T SampleRate is %f, want 100", item, envelope.SampleRate)
+		}
+	}
+
+	for _, item := range dropped {
+		if sampler.Process(envelopWithOperation(item, newUUID().String())) != nil {
+			t.Errorf("%T should have been sampled out", item)
+		}
+	}
+
+	sampler.Percentage = 100.0
+	for _, item := range dropped {
+		if envelope := sampler.Process(envelopWithOperation(item, newUUID().String())); envelope == nil || envelope.SampleRate != 100.0 {
+			t.Errorf("%T should have been kept at 100%%", item)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+//go:build go1.21
+// +build go1.21
+
+package appinsights
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// A log/slog Handler that tracks each log record as TraceTelemetry, or as
+// ExceptionTelemetry if one of its attributes is an error.  Attributes
+// become custom properties, with the names of enclosing groups joined to
+// their keys by dots.  Records logged with a context are tracked with
+// TrackWithContext, so they are correlated with the context's operation.
+type SlogHandler struct {
+	client     TelemetryClient
+	options    slog.HandlerOptions
+	groups     []string
+	properties map[string]string
+	err        error
+}
+
+// Creates a handler that tracks log records to the specified client.  The
+// Level, AddSource and ReplaceAttr options are honored; options may be nil
+// to log records at LevelInfo and above.
+func NewSlogHandler(client TelemetryClient, options *slog.HandlerOptions) *SlogHandler {
+	handler := &SlogHandler{
+		client:     client,
+		properties: make(map[string]string),
+	}
+
+	if options != nil {
+		handler.options = *options
+	}
+
+	return handler
+}
+
+// Reports whether records at the specified level are tracked.
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.options.Level != nil {
+		minLevel = h.options.Level.Level()
+	}
+
+	return level >= minLevel && h.client.IsEnabled()
+}
+
+// Tracks the specified log record.
+func (h *SlogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx == nil {
+		ctx = backgroundContext
+	}
+
+	properties := make(map[string]string, len(h.properties)+record.NumAttrs())
+	for k, v := range h.properties {
+		properties[k] = v
+	}
+
+	err := h.err
+	if h.options.AddSource && record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		h.addAttr(properties, &err, nil, slog.Group(slog.SourceKey,
+			slog.String("function", frame.Function),
+			slog.String("file", frame.File),
+			slog.Int("line", frame.Line)))
+	}
+
+	record.Attrs(func(attr slog.Attr) bool {
+		h.addAttr(properties, &err, h.groups, attr)
+		return true
+	})
+
+	severity := slogSeverityLevel(record.Level)
+	timestamp := record.Time
+	if timestamp.IsZero() {
+		timestamp = currentClock.Now()
+	}
+
+	var item Telemetry
+	if err != nil {
+		if _, ok := properties[slog.MessageKey]; !ok {
+			properties[slog.MessageKey] = record.Message
+		}
+
+		frames := ErrorCallstack(err)
+		if len(frames) == 0 {
+			frames = slogCallstack(record.PC)
+		}
+
+		item = &ExceptionTelemetry{
+			Error:         err,
+			Frames:        frames,
+			SeverityLevel: severity,
+			BaseTelemetry: BaseTelemetry{
+				Timestamp:  timestamp,
+				Tags:       make(contracts.ContextTags),
+				Properties: properties,
+			},
+			BaseTelemetryMeasurements: BaseTelemetryMeasurements{
+				Measurements: make(map[string]float64),
+			},
+		}
+	} else {
+		trace := NewTraceTelemetry(record.Message, severity)
+		trace.Timestamp = timestamp
+		trace.Properties = properties
+		item = trace
+	}
+
+	h.client.TrackWithContext(ctx, item)
+	return nil
+}
+
+// Returns a handler whose records include the specified attributes.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	result := h.clone()
+	for _, attr := range attrs {
+		result.addAttr(result.properties, &result.err, result.groups, attr)
+	}
+
+	return result
+}
+
+// Returns a handler that puts the attributes of its records in the
+// specified group.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	result := h.clone()
+	result.groups = append(result.groups[:len(result.groups):len(result.groups)], name)
+	return result
+}
+
+func (h *SlogHandler) clone() *SlogHandler {
+	result := *h
+	result.properties = make(map[string]string, len(h.properties))
+	for k, v := range h.properties {
+		result.properties[k] = v
+	}
+
+	return &result
+}
+
+// Adds an attribute, within the specified groups, to a set of properties.
+// If the attribute is the first error found, it is stored in err.
+func (h *SlogHandler) addAttr(properties map[string]string, err *error, groups []string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if h.options.ReplaceAttr != nil && attr.Value.Kind() != slog.KindGroup {
+		attr = h.options.ReplaceAttr(groups, attr)
+		attr.Value = attr.Value.Resolve()
+	}
+
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindGroup:
+		if attr.Key != "" {
+			groups = append(groups[:len(groups):len(groups)], attr.Key)
+		}
+
+		for _, member := range attr.Value.Group() {
+			h.addAttr(properties, err, groups, member)
+		}
+	case slog.KindTime:
+		properties[slogPropertyName(groups, attr.Key)] = attr.Value.Time().Format(time.RFC3339Nano)
+	case slog.KindAny:
+		if e, ok := attr.Value.Any().(error); ok {
+			if *err == nil {
+				*err = e
+			}
+
+			properties[slogPropertyName(groups, attr.Key)] = e.Error()
+			break
+		}
+
+		fallthrough
+	default:
+		properties[slogPropertyName(groups, attr.Key)] = attr.Value.String()
+	}
+}
+
+func slogPropertyName(groups []string, key string) string {
+	if len(groups) == 0 {
+		return key
+	}
+
+	return strings.Join(groups, ".") + "." + key
+}
+
+// Maps a slog level to the nearest severity level.  Levels well above
+// LevelError are considered Critical.
+func slogSeverityLevel(level slog.Level) contracts.SeverityLevel {
+	switch {
+	case level >= slog.LevelError+4:
+		return Critical
+	case level >= slog.LevelError:
+		return Error
+	case level >= slog.LevelWarn:
+		return Warning
+	case level >= slog.LevelInfo:
+		return Information
+	default:
+		return Verbose
+	}
+}
+
+// Gets the callstack starting at the site of a log call, identified by the
+// record's program counter.  Falls back to the single frame of the log call
+// if it is not on the current callstack.
+func slogCallstack(pc uintptr) []*contracts.StackFrame {
+	if pc == 0 {
+		return nil
+	}
+
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(1, pcs)]
+	for i, p := range pcs {
+		if p == pc {
+			return callstackFromPCs(pcs[i:])
+		}
+	}
+
+	return callstackFromPCs([]uintptr{pc})
+}
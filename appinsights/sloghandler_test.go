@@ -0,0 +1,124 @@
+//go:build go1.21
+// +build go1.21
+
+package appinsights
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+func TestSlogHandler(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	logger := slog.New(NewSlogHandler(client, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	logger = logger.With("service", "orders").WithGroup("req")
+
+	ctx, operation := client.StartRequestOperation(context.Background(), "op")
+	logger.DebugContext(ctx, "~debug~", "id", 42, slog.Group("user", "name", "alice", slog.Group("", "role", "admin")))
+	logger.Warn("~warn~", slog.Group("empty"))
+	logger.Log(context.Background(), slog.LevelError+4, "~critical~")
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 3 {
+		t.Fatalf("Tracked %d items, want 3", len(items))
+	}
+
+	debug := items[0].Data.(*contracts.Data).BaseData.(*contracts.MessageData)
+	checkDataContract(t, "Message", debug.Message, "~debug~")
+	checkDataContract(t, "SeverityLevel", debug.SeverityLevel, Verbose)
+	checkDataContract(t, "Properties[service]", debug.Properties["service"], "orders")
+	checkDataContract(t, "Properties[req.id]", debug.Properties["req.id"], "42")
+	checkDataContract(t, "Properties[req.user.name]", debug.Properties["req.user.name"], "alice")
+	checkDataContract(t, "Properties[req.user.role]", debug.Properties["req.user.role"], "admin")
+	checkDataContract(t, "ai.operation.id", items[0].Tags[contracts.OperationId], operation.OperationId())
+	checkDataContract(t, "ai.operation.parentId", items[0].Tags[contracts.OperationParentId], operation.Id())
+
+	warn := items[1].Data.(*contracts.Data).BaseData.(*contracts.MessageData)
+	checkDataContract(t, "SeverityLevel", warn.SeverityLevel, Warning)
+	if len(warn.Properties) != 1 {
+		t.Errorf("Unexpected properties: %v", warn.Properties)
+	}
+
+	critical := items[2].Data.(*contracts.Data).BaseData.(*contracts.MessageData)
+	checkDataContract(t, "SeverityLevel", critical.SeverityLevel, Critical)
+}
+
+func TestSlogHandlerError(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	logger := slog.New(NewSlogHandler(client, &slog.HandlerOptions{AddSource: true}))
+	logger.Error("~failed~", "err", errors.New("~boom~"), "attempt", 3)
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 1 {
+		t.Fatalf("Tracked %d items, want 1", len(items))
+	}
+
+	data, ok := items[0].Data.(*contracts.Data).BaseData.(*contracts.ExceptionData)
+	if !ok {
+		t.Fatalf("Expected exception telemetry, got %s", items[0].Name)
+	}
+
+	checkDataContract(t, "SeverityLevel", data.SeverityLevel, Error)
+	checkDataContract(t, "Message", data.Exceptions[0].Message, "~boom~")
+	checkDataContract(t, "Properties[msg]", data.Properties["msg"], "~failed~")
+	checkDataContract(t, "Properties[err]", data.Properties["err"], "~boom~")
+	checkDataContract(t, "Properties[attempt]", data.Properties["attempt"], "3")
+	if !strings.HasSuffix(data.Properties["source.function"], "TestSlogHandlerError") || data.Properties["source.line"] == "" {
+		t.Errorf("Unexpected source properties: %v", data.Properties)
+	}
+
+	frames := data.Exceptions[0].ParsedStack
+	if len(frames) == 0 || frames[0].Method != "TestSlogHandlerError" {
+		t.Error("Unexpected top stack frame")
+	}
+}
+
+func TestSlogHandlerOptions(t *testing.T) {
+	mockClock()
+	defer resetClock()
+	client, transmitter := newTestChannelServer()
+	defer transmitter.Close()
+
+	handler := NewSlogHandler(client, &slog.HandlerOptions{
+		Level: slog.LevelWarn,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == "password" {
+				return slog.Attr{}
+			}
+
+			return attr
+		},
+	})
+
+	if handler.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("Handler should not be enabled below its level")
+	}
+
+	logger := slog.New(handler)
+	logger.Info("~ignored~")
+	logger.Warn("~warn~", "user", "alice", "password", "hunter2")
+
+	items := closeAndGetItems(t, client, transmitter)
+	if len(items) != 1 {
+		t.Fatalf("Tracked %d items, want 1", len(items))
+	}
+
+	data := items[0].Data.(*contracts.Data).BaseData.(*contracts.MessageData)
+	checkDataContract(t, "Properties[user]", data.Properties["user"], "alice")
+	if _, ok := data.Properties["password"]; ok {
+		t.Error("ReplaceAttr was not applied")
+	}
+}
@@ -383,7 +383,7 @@ func NewRequestTelemetry(method, uri string, duration time.Duration, responseCod
 	success := true
 	code, err := strconv.Atoi(responseCode)
 	if err == nil {
-		success = code < 400 || code == 401
+		success = isSuccessResponseCode(code)
 	}
 
 	nameUri := uri
@@ -641,6 +641,12 @@ func (telem *PageViewTelemetry) TelemetryData() TelemetryData {
 	return data
 }
 
+// Determines whether an HTTP response code indicates success.  401 is
+// considered successful since it is a normal part of authentication.
+func isSuccessResponseCode(code int) bool {
+	return code < 400 || code == 401
+}
+
 func formatDuration(d time.Duration) string {
 	ticks := int64(d/(time.Nanosecond*100)) % 10000000
 	seconds := int64(d/time.Second) % 60
@@ -48,3 +48,24 @@ type TelemetryChannel interface {
 	// long delays.
 	Close(retryTimeout ...time.Duration) <-chan struct{}
 }
+
+// Implemented by telemetry channels that count the items they discard,
+// including InMemoryChannel and PersistentChannel.  Use a type assertion on
+// TelemetryClient.Channel() to reach it.
+type DroppedItemsChannel interface {
+	// Returns the number of telemetry items that have been discarded
+	// because the queue was full or the channel was throttled.
+	DroppedItems() int64
+}
+
+// Implemented by channels that can stop background sources of telemetry,
+// such as PerformanceCollector, when they are closed or stopped.
+type closeHookChannel interface {
+	onClose(f func())
+}
+
+// Implemented by channels that report the outcome of their most recent
+// transmission, for HeartbeatProvider.
+type transmitStatusChannel interface {
+	lastTransmitSucceeded() (succeeded, ok bool)
+}
@@ -1,6 +1,7 @@
 package appinsights
 
 import (
+	"context"
 	"strings"
 
 	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
@@ -23,6 +24,18 @@ type TelemetryContext struct {
 	// an effect from the TelemetryClient's context instance.  This will
 	// be nil on telemetry items.
 	CommonProperties map[string]string
+
+	// Initializers to run on each telemetry item, in order, before it is
+	// wrapped in an envelope.
+	Initializers []TelemetryInitializer
+
+	// Correlation headers read and written by HttpHandler and
+	// HttpTransport.
+	Propagation PropagationMode
+
+	// Application ID exchanged in Request-Context headers by HttpHandler
+	// and HttpTransport, if set.
+	ApplicationId string
 }
 
 // Creates a new, empty TelemetryContext
@@ -44,6 +57,17 @@ func (context *TelemetryContext) InstrumentationKey() string {
 // Wraps a telemetry item in an envelope with the information found in this
 // context.
 func (context *TelemetryContext) envelop(item Telemetry) *contracts.Envelope {
+	return context.envelopWithContext(backgroundContext, item)
+}
+
+// Wraps a telemetry item in an envelope with the information found in this
+// context, after running initializers with the specified context.Context.
+func (context *TelemetryContext) envelopWithContext(ctx context.Context, item Telemetry) *contracts.Envelope {
+	// Run initializers
+	for _, initializer := range context.Initializers {
+		initializer.Initialize(ctx, item)
+	}
+
 	// Apply common properties
 	if props := item.GetProperties(); props != nil && context.CommonProperties != nil {
 		for k, v := range context.CommonProperties {
@@ -89,7 +113,7 @@ func (context *TelemetryContext) envelop(item Telemetry) *contracts.Envelope {
 
 	// Create operation ID if it does not exist
 	if _, ok := envelope.Tags[contracts.OperationId]; !ok {
-		envelope.Tags[contracts.OperationId] = newUUID().String()
+		envelope.Tags[contracts.OperationId] = newTraceId()
 	}
 
 	// Sanitize.
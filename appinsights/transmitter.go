@@ -78,6 +78,7 @@ func (transmitter *httpTransmitter) Transmit(payload []byte, items telemetryBuff
 		return nil, err
 	}
 
+	req = req.WithContext(withInternalRequest(req.Context()))
 	req.Header.Set("Content-Encoding", "gzip")
 	req.Header.Set("Content-Type", "application/x-json-stream")
 	req.Header.Set("Accept-Encoding", "gzip, deflate")